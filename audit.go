@@ -0,0 +1,258 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// auditChecks are the security header checks, in report order.
+var auditChecks = []string{
+	"hsts",
+	"csp",
+	"x-content-type-options",
+	"frame-options",
+	"referrer-policy",
+	"permissions-policy",
+	"cookie-secure",
+	"cookie-httponly",
+	"cookie-samesite",
+}
+
+// auditPolicy is what a team requires of its responses. Checks maps a check name to "fail", which makes a finding a
+// violation and the exit status non-zero, "warn", which only reports it, or "off".
+type auditPolicy struct {
+	Checks                  map[string]string `json:"checks"`
+	HSTSMinMaxAge           int64             `json:"hstsMinMaxAge"`
+	HSTSIncludeSubDomains   bool              `json:"hstsIncludeSubDomains"`
+	HSTSPreload             bool              `json:"hstsPreload"`
+	CSPForbidUnsafeInline   bool              `json:"cspForbidUnsafeInline"`
+	AllowedReferrerPolicies []string          `json:"allowedReferrerPolicies"`
+}
+
+func defaultAuditPolicy() *auditPolicy {
+	return &auditPolicy{
+		Checks: map[string]string{
+			"hsts":                   "fail",
+			"csp":                    "warn",
+			"x-content-type-options": "fail",
+			"frame-options":          "fail",
+			"referrer-policy":        "warn",
+			"permissions-policy":     "warn",
+			"cookie-secure":          "fail",
+			"cookie-httponly":        "warn",
+			"cookie-samesite":        "warn",
+		},
+		HSTSMinMaxAge:           15552000, // 180 days
+		CSPForbidUnsafeInline:   true,
+		AllowedReferrerPolicies: []string{"no-referrer", "same-origin", "strict-origin", "strict-origin-when-cross-origin"},
+	}
+}
+
+// loadAuditPolicy reads a policy file over the defaults, so a file only needs the settings it changes.
+func loadAuditPolicy(path string) (*auditPolicy, error) {
+	policy := defaultAuditPolicy()
+	if path == "" {
+		return policy, nil
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	checks := policy.Checks
+	policy.Checks = nil
+	if err := json.Unmarshal(data, policy); err != nil {
+		return nil, fmt.Errorf("parsing %s: %w", path, err)
+	}
+	for name, severity := range policy.Checks {
+		if !slices.Contains(auditChecks, name) {
+			return nil, fmt.Errorf("%s: unknown check %q", path, name)
+		}
+		if severity != "fail" && severity != "warn" && severity != "off" {
+			return nil, fmt.Errorf("%s: check %q has severity %q, want fail, warn or off", path, name, severity)
+		}
+		checks[name] = severity
+	}
+	policy.Checks = checks
+	return policy, nil
+}
+
+// auditResponse grades the security headers of resp against policy and returns an error if any check set to
+// "fail" found a problem.
+func auditResponse(resp *http.Response, policy *auditPolicy) error {
+	https := resp.Request != nil && resp.Request.URL.Scheme == "https"
+	fmt.Printf("Security audit:\n")
+	var fails, warns int
+	for _, check := range auditChecks {
+		severity := policy.Checks[check]
+		if severity == "off" {
+			continue
+		}
+		if check == "hsts" && !https {
+			fmt.Printf("  SKIP %s: only meaningful over https\n", check)
+			continue
+		}
+		problems := policy.run(check, resp)
+		if len(problems) == 0 {
+			fmt.Printf("  PASS %s\n", check)
+			continue
+		}
+		label := "WARN"
+		if severity == "fail" {
+			label = "FAIL"
+			fails++
+		} else {
+			warns++
+		}
+		for _, p := range problems {
+			fmt.Printf("  %s %s: %s\n", label, check, p)
+		}
+	}
+
+	grade := "A"
+	switch {
+	case fails > 0:
+		grade = "F"
+	case warns > 2:
+		grade = "C"
+	case warns > 0:
+		grade = "B"
+	}
+	fmt.Printf("Grade: %s (%d violations, %d warnings)\n", grade, fails, warns)
+	if fails > 0 {
+		return newError(errCheckFailed, "security audit found %d policy violations", fails)
+	}
+	return nil
+}
+
+func (p *auditPolicy) run(check string, resp *http.Response) []string {
+	h := resp.Header
+	switch check {
+	case "hsts":
+		return p.checkHSTS(h.Get("Strict-Transport-Security"))
+	case "csp":
+		return p.checkCSP(h.Get("Content-Security-Policy"))
+	case "x-content-type-options":
+		if v := h.Get("X-Content-Type-Options"); !strings.EqualFold(strings.TrimSpace(v), "nosniff") {
+			return []string{fmt.Sprintf("want nosniff, got %q", v)}
+		}
+	case "frame-options":
+		v := strings.ToUpper(strings.TrimSpace(h.Get("X-Frame-Options")))
+		if v != "DENY" && v != "SAMEORIGIN" && cspDirective(h.Get("Content-Security-Policy"), "frame-ancestors") == "" {
+			return []string{"neither X-Frame-Options DENY/SAMEORIGIN nor CSP frame-ancestors is set"}
+		}
+	case "referrer-policy":
+		v := h.Get("Referrer-Policy")
+		if v == "" {
+			return []string{"missing"}
+		}
+		// Browsers use the last value they understand.
+		values := splitList(v)
+		if len(values) == 0 {
+			return []string{fmt.Sprintf("%q names no policy", v)}
+		}
+		if last := strings.ToLower(values[len(values)-1]); !slices.Contains(p.AllowedReferrerPolicies, last) {
+			return []string{fmt.Sprintf("%q is not one of %s", last, strings.Join(p.AllowedReferrerPolicies, ", "))}
+		}
+	case "permissions-policy":
+		if h.Get("Permissions-Policy") == "" {
+			return []string{"missing"}
+		}
+	case "cookie-secure", "cookie-httponly", "cookie-samesite":
+		return checkCookies(check, resp.Cookies())
+	}
+	return nil
+}
+
+func (p *auditPolicy) checkHSTS(v string) []string {
+	if v == "" {
+		return []string{"missing"}
+	}
+	var problems []string
+	var maxAge int64 = -1
+	var subdomains, preload bool
+	for _, directive := range strings.Split(v, ";") {
+		name, value, _ := strings.Cut(strings.TrimSpace(directive), "=")
+		switch strings.ToLower(name) {
+		case "max-age":
+			if n, err := strconv.ParseInt(strings.Trim(value, `"`), 10, 64); err == nil {
+				maxAge = n
+			}
+		case "includesubdomains":
+			subdomains = true
+		case "preload":
+			preload = true
+		}
+	}
+	if maxAge < p.HSTSMinMaxAge {
+		problems = append(problems, fmt.Sprintf("max-age %d is below %d", maxAge, p.HSTSMinMaxAge))
+	}
+	if p.HSTSIncludeSubDomains && !subdomains {
+		problems = append(problems, "includeSubDomains missing")
+	}
+	if p.HSTSPreload && !preload {
+		problems = append(problems, "preload missing")
+	}
+	// The preload list only accepts a year or more with includeSubDomains.
+	if preload && (maxAge < 31536000 || !subdomains) {
+		problems = append(problems, "preload needs max-age of at least 31536000 and includeSubDomains")
+	}
+	return problems
+}
+
+func (p *auditPolicy) checkCSP(v string) []string {
+	if v == "" {
+		return []string{"missing"}
+	}
+	var problems []string
+	script := cspDirective(v, "script-src")
+	if script == "" {
+		script = cspDirective(v, "default-src")
+	}
+	if script == "" {
+		problems = append(problems, "neither script-src nor default-src restricts scripts")
+	}
+	if p.CSPForbidUnsafeInline {
+		for _, keyword := range []string{"'unsafe-inline'", "'unsafe-eval'"} {
+			if strings.Contains(script, keyword) {
+				problems = append(problems, "scripts allow "+keyword)
+			}
+		}
+	}
+	return problems
+}
+
+// cspDirective returns the value of a Content-Security-Policy directive, or "" if it is absent.
+func cspDirective(csp, name string) string {
+	for _, directive := range strings.Split(csp, ";") {
+		fields := strings.Fields(directive)
+		if len(fields) > 0 && strings.EqualFold(fields[0], name) {
+			if len(fields) == 1 {
+				return "'none'"
+			}
+			return strings.Join(fields[1:], " ")
+		}
+	}
+	return ""
+}
+
+func checkCookies(check string, cookies []*http.Cookie) []string {
+	var problems []string
+	for _, c := range cookies {
+		switch {
+		case check == "cookie-secure" && !c.Secure:
+			problems = append(problems, fmt.Sprintf("cookie %s lacks Secure", c.Name))
+		case check == "cookie-httponly" && !c.HttpOnly:
+			problems = append(problems, fmt.Sprintf("cookie %s lacks HttpOnly", c.Name))
+		case check == "cookie-samesite" && (c.SameSite == 0 || c.SameSite == http.SameSiteDefaultMode):
+			problems = append(problems, fmt.Sprintf("cookie %s lacks SameSite", c.Name))
+		case check == "cookie-samesite" && c.SameSite == http.SameSiteNoneMode && !c.Secure:
+			problems = append(problems, fmt.Sprintf("cookie %s has SameSite=None without Secure", c.Name))
+		}
+	}
+	return problems
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+func TestAuditPolicyRun(t *testing.T) {
+	p := defaultAuditPolicy()
+	tests := []struct {
+		check   string
+		header  http.Header
+		problem bool
+	}{
+		{"hsts", http.Header{"Strict-Transport-Security": {"max-age=31536000; includeSubDomains"}}, false},
+		{"hsts", http.Header{"Strict-Transport-Security": {"max-age=60"}}, true},
+		{"hsts", http.Header{"Strict-Transport-Security": {"max-age=31536000; preload"}}, true},
+		{"hsts", http.Header{}, true},
+		{"csp", http.Header{"Content-Security-Policy": {"default-src 'self'"}}, false},
+		{"csp", http.Header{"Content-Security-Policy": {"script-src 'self' 'unsafe-inline'"}}, true},
+		{"csp", http.Header{"Content-Security-Policy": {"img-src *"}}, true},
+		{"x-content-type-options", http.Header{"X-Content-Type-Options": {" NoSniff "}}, false},
+		{"x-content-type-options", http.Header{}, true},
+		{"frame-options", http.Header{"X-Frame-Options": {"sameorigin"}}, false},
+		{"frame-options", http.Header{"Content-Security-Policy": {"frame-ancestors 'none'"}}, false},
+		{"frame-options", http.Header{"X-Frame-Options": {"ALLOW-FROM https://a"}}, true},
+		{"referrer-policy", http.Header{"Referrer-Policy": {"unsafe-url, strict-origin"}}, false},
+		{"referrer-policy", http.Header{"Referrer-Policy": {"no-referrer, unsafe-url"}}, true},
+		{"referrer-policy", http.Header{"Referrer-Policy": {","}}, true},
+		{"referrer-policy", http.Header{"Referrer-Policy": {" , "}}, true},
+		{"referrer-policy", http.Header{}, true},
+		{"permissions-policy", http.Header{"Permissions-Policy": {"camera=()"}}, false},
+		{"cookie-secure", http.Header{"Set-Cookie": {"a=b; Secure"}}, false},
+		{"cookie-secure", http.Header{"Set-Cookie": {"a=b"}}, true},
+		{"cookie-httponly", http.Header{"Set-Cookie": {"a=b; HttpOnly"}}, false},
+		{"cookie-samesite", http.Header{"Set-Cookie": {"a=b; SameSite=Lax"}}, false},
+		{"cookie-samesite", http.Header{"Set-Cookie": {"a=b; SameSite=None"}}, true},
+		{"cookie-samesite", http.Header{"Set-Cookie": {"a=b"}}, true},
+	}
+	for _, tt := range tests {
+		problems := p.run(tt.check, &http.Response{Header: tt.header})
+		if (len(problems) > 0) != tt.problem {
+			t.Errorf("%s with %v: problems %q, want problems %v", tt.check, tt.header, problems, tt.problem)
+		}
+	}
+}
+
+func TestLoadAuditPolicy(t *testing.T) {
+	tests := []struct {
+		name    string
+		file    string
+		wantErr bool
+	}{
+		{name: "override", file: `{"checks": {"csp": "fail"}, "hstsPreload": true}`},
+		{name: "unknown check", file: `{"checks": {"x-xss-protection": "fail"}}`, wantErr: true},
+		{name: "bad severity", file: `{"checks": {"csp": "error"}}`, wantErr: true},
+		{name: "bad JSON", file: `{"checks":`, wantErr: true},
+	}
+	for _, tt := range tests {
+		path := filepath.Join(t.TempDir(), "policy.json")
+		if err := os.WriteFile(path, []byte(tt.file), 0o600); err != nil {
+			t.Fatal(err)
+		}
+		p, err := loadAuditPolicy(path)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("%s: error = %v, want error %v", tt.name, err, tt.wantErr)
+			continue
+		}
+		if tt.wantErr {
+			continue
+		}
+		// Settings the file leaves out keep their defaults.
+		if p.Checks["csp"] != "fail" || p.Checks["hsts"] != "fail" || !p.HSTSPreload || !slices.Contains(p.AllowedReferrerPolicies, "no-referrer") {
+			t.Errorf("%s: policy = %+v", tt.name, p)
+		}
+	}
+}
@@ -0,0 +1,202 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// cdnCacheHeaders are the response headers CDNs and caching proxies use to say whether a request was a hit.
+var cdnCacheHeaders = []string{"Cache-Status", "X-Cache", "X-Cache-Status", "CF-Cache-Status", "X-Cache-Hits", "X-Served-By", "Akamai-Cache-Status", "X-Proxy-Cache", "X-Varnish"}
+
+// heuristicallyCacheable are the status codes a cache may store without explicit freshness (RFC 9110 section 15.1).
+var heuristicallyCacheable = map[int]bool{200: true, 203: true, 204: true, 206: true, 300: true, 301: true, 308: true, 404: true, 405: true, 410: true, 414: true, 501: true}
+
+// cacheControl is a parsed Cache-Control header. Directives without a value map to "".
+type cacheControl map[string]string
+
+func parseCacheControl(h http.Header) cacheControl {
+	cc := cacheControl{}
+	for _, line := range h.Values("Cache-Control") {
+		for _, directive := range strings.Split(line, ",") {
+			name, value, _ := strings.Cut(strings.TrimSpace(directive), "=")
+			if name != "" {
+				cc[strings.ToLower(name)] = strings.Trim(value, `"`)
+			}
+		}
+	}
+	return cc
+}
+
+func (cc cacheControl) has(name string) bool {
+	_, ok := cc[name]
+	return ok
+}
+
+func (cc cacheControl) seconds(name string) (time.Duration, bool) {
+	v, ok := cc[name]
+	if !ok {
+		return 0, false
+	}
+	n, err := strconv.ParseInt(v, 10, 64)
+	if err != nil || n < 0 {
+		return 0, false
+	}
+	return time.Duration(n) * time.Second, true
+}
+
+// freshness works out how long a shared cache may serve resp without revalidating, following RFC 9111 section
+// 4.2.1, and where that figure came from.
+func freshness(resp *http.Response, cc cacheControl) (time.Duration, string) {
+	if d, ok := cc.seconds("s-maxage"); ok {
+		return d, "s-maxage"
+	}
+	if d, ok := cc.seconds("max-age"); ok {
+		return d, "max-age"
+	}
+	date, dateErr := http.ParseTime(resp.Header.Get("Date"))
+	if dateErr != nil {
+		date = time.Now()
+	}
+	if expires := resp.Header.Get("Expires"); expires != "" {
+		t, err := http.ParseTime(expires)
+		if err != nil {
+			// An invalid Expires, commonly "0" or "-1", means already expired.
+			return 0, "invalid Expires"
+		}
+		return max(t.Sub(date), 0), "Expires"
+	}
+	if lm, err := http.ParseTime(resp.Header.Get("Last-Modified")); err == nil && heuristicallyCacheable[resp.StatusCode] {
+		return max(date.Sub(lm)/10, 0).Round(time.Second), "heuristic, 10% of time since Last-Modified"
+	}
+	return 0, "no explicit freshness"
+}
+
+// analyzeCache fetches urlStr, explains how caches may treat the response, and then revalidates it with its
+// validators to see whether the server answers 304 Not Modified.
+func (s *session) analyzeCache(urlStr string, overrides dialOverrides, target string) error {
+	req, err := http.NewRequest(http.MethodGet, urlStr, nil)
+	if err != nil {
+		return newError(errBadInput, "building request failed: %v", err)
+	}
+	resp, body, err := s.fetch(req, overrides)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("Cache analysis for %s", urlStr)
+	if target != "" {
+		fmt.Printf(" via %s", target)
+	}
+	fmt.Printf("\nStatus: %s (%d bytes)\n", resp.Status, len(body))
+
+	cc := parseCacheControl(resp.Header)
+	for _, name := range []string{"Cache-Control", "Expires", "Age", "Date", "Last-Modified", "ETag", "Vary", "Pragma"} {
+		if v := resp.Header.Get(name); v != "" {
+			fmt.Printf("  %s: %s\n", name, v)
+		}
+	}
+	for _, name := range cdnCacheHeaders {
+		for _, v := range resp.Header.Values(name) {
+			fmt.Printf("  %s: %s (CDN)\n", name, v)
+		}
+	}
+
+	ttl, source := freshness(resp, cc)
+	var age time.Duration
+	if n, err := strconv.ParseInt(resp.Header.Get("Age"), 10, 64); err == nil && n > 0 {
+		age = time.Duration(n) * time.Second
+	}
+
+	shared := false
+	switch {
+	case cc.has("no-store"):
+		fmt.Printf("Cacheability: not cacheable (no-store)\n")
+	case cc.has("private"):
+		fmt.Printf("Cacheability: browser only (private), not stored by shared caches or CDNs\n")
+	case !heuristicallyCacheable[resp.StatusCode] && source == "no explicit freshness":
+		fmt.Printf("Cacheability: not cacheable (status %d without explicit freshness)\n", resp.StatusCode)
+	case (cc.has("no-cache") || ttl == 0) && resp.Header.Get("ETag") == "" && resp.Header.Get("Last-Modified") == "":
+		fmt.Printf("Cacheability: effectively not reusable (every use needs revalidation, but there are no validators)\n")
+	case cc.has("no-cache") || ttl == 0:
+		shared = true
+		fmt.Printf("Cacheability: stored, but revalidated on every use\n")
+	default:
+		shared = true
+		fmt.Printf("Cacheability: cacheable by shared caches\n")
+	}
+	fmt.Printf("TTL: %s (%s)\n", ttl, source)
+	if age > 0 {
+		fmt.Printf("Age: %s in cache, %s of freshness left\n", age, max(ttl-age, 0))
+	}
+	if cc.has("must-revalidate") || cc.has("proxy-revalidate") {
+		fmt.Printf("Stale use: forbidden (must-revalidate)\n")
+	} else if d, ok := cc.seconds("stale-while-revalidate"); ok {
+		fmt.Printf("Stale use: served for %s while revalidating\n", d)
+	}
+	if d, ok := cc.seconds("stale-if-error"); ok {
+		fmt.Printf("Stale use: served for %s on origin errors\n", d)
+	}
+	if vary := resp.Header.Get("Vary"); vary != "" {
+		if strings.TrimSpace(vary) == "*" {
+			fmt.Printf("Vary: * makes every request a cache miss\n")
+		} else {
+			fmt.Printf("Vary: cached separately per %s\n", vary)
+		}
+	}
+	if shared && len(resp.Header.Values("Set-Cookie")) > 0 {
+		fmt.Printf("Warning: response sets cookies but may be stored by shared caches\n")
+	}
+
+	return s.checkRevalidation(urlStr, overrides, resp)
+}
+
+// checkRevalidation replays the request with the validators from resp and reports whether the 304 path works.
+func (s *session) checkRevalidation(urlStr string, overrides dialOverrides, resp *http.Response) error {
+	etag := resp.Header.Get("ETag")
+	lastModified := resp.Header.Get("Last-Modified")
+	if etag == "" && lastModified == "" {
+		fmt.Printf("Revalidation: impossible, no ETag or Last-Modified\n")
+		return nil
+	}
+
+	req, err := http.NewRequest(http.MethodGet, urlStr, nil)
+	if err != nil {
+		return newError(errBadInput, "building request failed: %v", err)
+	}
+	var sent []string
+	if etag != "" {
+		req.Header.Set("If-None-Match", etag)
+		sent = append(sent, "If-None-Match: "+etag)
+	}
+	if lastModified != "" {
+		req.Header.Set("If-Modified-Since", lastModified)
+		sent = append(sent, "If-Modified-Since: "+lastModified)
+	}
+	reval, body, err := s.fetch(req, overrides)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("Revalidation with %s:\n", strings.Join(sent, ", "))
+	switch reval.StatusCode {
+	case http.StatusNotModified:
+		fmt.Printf("  304 Not Modified: works\n")
+		if len(body) > 0 {
+			fmt.Printf("  Warning: 304 carried a %d byte body\n", len(body))
+		}
+		if got := reval.Header.Get("ETag"); etag != "" && got != "" && got != etag {
+			fmt.Printf("  Warning: 304 ETag %s differs from %s\n", got, etag)
+		}
+	case resp.StatusCode:
+		fmt.Printf("  %s: validators ignored, full response sent again (%d bytes)\n", reval.Status, len(body))
+		if got := reval.Header.Get("ETag"); got != etag {
+			fmt.Printf("  ETag changed from %s to %s\n", etag, got)
+		}
+	default:
+		fmt.Printf("  %s: unexpected status\n", reval.Status)
+	}
+	return nil
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"maps"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseCacheControl(t *testing.T) {
+	tests := []struct {
+		name   string
+		values []string
+		want   cacheControl
+	}{
+		{name: "none", want: cacheControl{}},
+		{name: "values and flags", values: []string{`public, Max-Age=60, s-maxage="120"`}, want: cacheControl{"public": "", "max-age": "60", "s-maxage": "120"}},
+		{name: "several lines", values: []string{"no-cache", "private, ,must-revalidate"}, want: cacheControl{"no-cache": "", "private": "", "must-revalidate": ""}},
+	}
+	for _, tt := range tests {
+		got := parseCacheControl(http.Header{"Cache-Control": tt.values})
+		if !maps.Equal(got, tt.want) {
+			t.Errorf("%s: parseCacheControl() = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+	if d, ok := (cacheControl{"max-age": "-1"}).seconds("max-age"); ok {
+		t.Errorf("negative max-age parsed as %v", d)
+	}
+}
+
+func TestFreshness(t *testing.T) {
+	date := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	httpTime := func(t time.Time) string { return t.Format(http.TimeFormat) }
+	tests := []struct {
+		name       string
+		status     int
+		header     http.Header
+		want       time.Duration
+		wantSource string
+	}{
+		{name: "s-maxage wins", header: http.Header{"Cache-Control": {"max-age=60, s-maxage=30"}}, want: 30 * time.Second, wantSource: "s-maxage"},
+		{name: "max-age over Expires", header: http.Header{"Cache-Control": {"max-age=60"}, "Expires": {"0"}}, want: time.Minute, wantSource: "max-age"},
+		{name: "Expires", header: http.Header{"Date": {httpTime(date)}, "Expires": {httpTime(date.Add(time.Hour))}}, want: time.Hour, wantSource: "Expires"},
+		{name: "Expires in the past", header: http.Header{"Date": {httpTime(date)}, "Expires": {httpTime(date.Add(-time.Hour))}}, wantSource: "Expires"},
+		{name: "invalid Expires", header: http.Header{"Expires": {"-1"}}, wantSource: "invalid Expires"},
+		{name: "heuristic", header: http.Header{"Date": {httpTime(date)}, "Last-Modified": {httpTime(date.Add(-10 * time.Hour))}}, want: time.Hour, wantSource: "heuristic, 10% of time since Last-Modified"},
+		{name: "no heuristic for 302", status: http.StatusFound, header: http.Header{"Last-Modified": {httpTime(date)}}, wantSource: "no explicit freshness"},
+		{name: "nothing", header: http.Header{}, wantSource: "no explicit freshness"},
+	}
+	for _, tt := range tests {
+		resp := &http.Response{StatusCode: http.StatusOK, Header: tt.header}
+		if tt.status != 0 {
+			resp.StatusCode = tt.status
+		}
+		got, source := freshness(resp, parseCacheControl(tt.header))
+		if got != tt.want || source != tt.wantSource {
+			t.Errorf("%s: freshness() = %v, %q, want %v, %q", tt.name, got, source, tt.want, tt.wantSource)
+		}
+	}
+}
+
+func TestAnalyzeCache(t *testing.T) {
+	const lastModified = "Wed, 01 May 2024 12:00:00 GMT"
+	tests := []struct {
+		name     string
+		header   http.Header
+		honor    bool // answer matching validators with 304
+		wantSays []string
+	}{
+		{
+			name:     "revalidated",
+			header:   http.Header{"Cache-Control": {"max-age=60"}, "Etag": {`"v1"`}},
+			honor:    true,
+			wantSays: []string{"cacheable by shared caches", "Revalidation with If-None-Match: \"v1\":", "304 Not Modified: works"},
+		},
+		{
+			name:     "validators ignored",
+			header:   http.Header{"Cache-Control": {"no-cache"}, "Last-Modified": {lastModified}},
+			wantSays: []string{"revalidated on every use", "If-Modified-Since: " + lastModified, "200 OK: validators ignored"},
+		},
+		{
+			name:     "no freshness and no validators",
+			header:   http.Header{},
+			wantSays: []string{"effectively not reusable", "Revalidation: impossible"},
+		},
+		{
+			name:     "no-store",
+			header:   http.Header{"Cache-Control": {"no-store"}},
+			wantSays: []string{"not cacheable (no-store)"},
+		},
+	}
+	for _, tt := range tests {
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			maps.Copy(w.Header(), tt.header)
+			if tt.honor && (r.Header.Get("If-None-Match") != "" || r.Header.Get("If-Modified-Since") != "") {
+				w.WriteHeader(http.StatusNotModified)
+				return
+			}
+			w.Write([]byte("body"))
+		}))
+		s, err := newSession(options{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		out := captureStdout(t, func() { err = s.analyzeCache(srv.URL, nil, "") })
+		s.close()
+		srv.Close()
+		if err != nil {
+			t.Errorf("%s: analyzeCache() = %v", tt.name, err)
+		}
+		for _, want := range tt.wantSays {
+			if !strings.Contains(out, want) {
+				t.Errorf("%s: output lacks %q:\n%s", tt.name, want, out)
+			}
+		}
+		if strings.Contains(out, "effectively not reusable") && strings.Contains(out, "revalidated on every use") {
+			t.Errorf("%s: contradictory cacheability:\n%s", tt.name, out)
+		}
+	}
+}
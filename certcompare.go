@@ -0,0 +1,217 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"slices"
+	"strings"
+	"time"
+)
+
+// certAttributes are the properties of a presented certificate that every node behind one name should agree on.
+var certAttributes = []string{"Leaf fingerprint", "Chain", "Expiry", "SANs", "OCSP staple", "Verification"}
+
+// certView is what one dial target presented, with values indexed like certAttributes, or why it presented nothing.
+type certView struct {
+	target string
+	values []string
+	err    error
+}
+
+// compareCerts handshakes with every target for host:port and reports the targets whose certificate differs from
+// what the majority presents. With no targets, every address the name resolves to is compared.
+func (s *session) compareCerts(host, port string, targets []string) error {
+	addr := net.JoinHostPort(host, port)
+	if len(targets) == 0 {
+		ctx, cancel := context.WithTimeout(context.Background(), dialTimeout*4)
+		defer cancel()
+		addrs, err := net.DefaultResolver.LookupHost(ctx, host)
+		if err != nil {
+			return &toolError{kind: classifyError(err), err: fmt.Errorf("resolving %s failed: %w", host, err)}
+		}
+		if len(addrs) < 2 {
+			return newError(errBadInput, "%s resolves to %d address, give the ips to compare after the url", host, len(addrs))
+		}
+		targets = addrs
+	}
+	if len(targets) < 2 {
+		return newError(errBadInput, "comparing certificates needs at least two dial targets, got %d", len(targets))
+	}
+
+	fmt.Printf("Certificate comparison of %s across %d targets\n", addr, len(targets))
+	views := make([]certView, len(targets))
+	reachable := 0
+	for i, target := range targets {
+		if _, _, err := net.SplitHostPort(target); err != nil {
+			target = net.JoinHostPort(strings.Trim(target, "[]"), port)
+		}
+		v := certView{target: target}
+		v.values, v.err = s.presentedCert(host, addr, target)
+		views[i] = v
+		fmt.Printf("%s:\n", target)
+		if v.err != nil {
+			fmt.Printf("  failed: %v\n", v.err)
+			continue
+		}
+		reachable++
+		for j, name := range certAttributes {
+			fmt.Printf("  %s: %s\n", name, v.values[j])
+		}
+	}
+	if reachable == 0 {
+		return &toolError{kind: kindOf(views[0].err), err: fmt.Errorf("no target completed a handshake: %w", views[0].err)}
+	}
+
+	outliers := map[string]bool{}
+	for _, v := range views {
+		if v.err != nil {
+			outliers[v.target] = true
+		}
+	}
+	for j, name := range certAttributes {
+		// Group the reachable targets by value, keeping the order of first appearance.
+		var values []string
+		byValue := map[string][]string{}
+		for _, v := range views {
+			if v.err != nil {
+				continue
+			}
+			if _, ok := byValue[v.values[j]]; !ok {
+				values = append(values, v.values[j])
+			}
+			byValue[v.values[j]] = append(byValue[v.values[j]], v.target)
+		}
+		if len(values) == 1 {
+			fmt.Printf("%s: consistent\n", name)
+			continue
+		}
+		slices.SortStableFunc(values, func(a, b string) int { return len(byValue[b]) - len(byValue[a]) })
+		majority := values[0]
+		if len(byValue[majority])*2 <= reachable {
+			fmt.Printf("%s: inconsistent, no majority\n", name)
+			majority = ""
+		} else {
+			fmt.Printf("%s: %d of %d targets agree on %s\n", name, len(byValue[majority]), reachable, majority)
+		}
+		for _, value := range values {
+			if value == majority {
+				continue
+			}
+			for _, target := range byValue[value] {
+				fmt.Printf("  outlier %s: %s\n", target, value)
+				outliers[target] = true
+			}
+		}
+	}
+
+	if len(outliers) > 0 {
+		var names []string
+		for _, v := range views {
+			if outliers[v.target] {
+				names = append(names, v.target)
+			}
+		}
+		fmt.Printf("Outliers: %d of %d targets (%s)\n", len(names), len(views), strings.Join(names, ", "))
+		return newError(errCheckFailed, "certificates differ across the targets of %s", addr)
+	}
+	fmt.Printf("All %d targets present the same certificate\n", len(views))
+	return nil
+}
+
+// presentedCert handshakes with addr dialed at target and describes the certificate it presents. Verification is done
+// afterwards rather than in the handshake, so that a node with a bad certificate still shows what it serves.
+func (s *session) presentedCert(host, addr, target string) ([]string, error) {
+	ctx := withConnConfig(withDialOverrides(context.Background(), dialOverrides{overrideKey(addr): target}), s.conn)
+	conn, err := dialOverridden(ctx, "tcp", addr)
+	if err != nil {
+		return nil, &toolError{kind: classifyError(err), err: fmt.Errorf("connecting failed: %w", err)}
+	}
+	defer conn.Close()
+	cfg := sharedTLSConfig.Clone()
+	cfg.ServerName = host
+	cfg.InsecureSkipVerify = true
+	tc := tls.Client(conn, cfg)
+	hsCtx, cancel := context.WithTimeout(ctx, tlsScanHandshakeTimeout)
+	defer cancel()
+	if err := tc.HandshakeContext(hsCtx); err != nil {
+		return nil, &toolError{kind: classifyError(err), err: fmt.Errorf("TLS handshake failed: %w", err)}
+	}
+	state := tc.ConnectionState()
+	certs := state.PeerCertificates
+	leaf := certs[0]
+
+	var chain []string
+	for _, c := range certs[1:] {
+		sum := sha256.Sum256(c.Raw)
+		chain = append(chain, fmt.Sprintf("%s (%x)", c.Subject, sum[:4]))
+	}
+	if len(chain) == 0 {
+		chain = []string{"leaf only"}
+	}
+
+	sans := slices.Clone(leaf.DNSNames)
+	for _, ip := range leaf.IPAddresses {
+		sans = append(sans, ip.String())
+	}
+	slices.Sort(sans)
+
+	opts := x509.VerifyOptions{DNSName: host, Roots: cfg.RootCAs, Intermediates: x509.NewCertPool()}
+	for _, c := range certs[1:] {
+		opts.Intermediates.AddCert(c)
+	}
+	verification := "OK"
+	var issuer *x509.Certificate
+	if chains, err := leaf.Verify(opts); err != nil {
+		verification = err.Error()
+		if len(certs) > 1 {
+			issuer = certs[1]
+		}
+	} else if len(chains[0]) > 1 {
+		issuer = chains[0][1]
+	}
+
+	// Nodes fetch their staples independently, so only the status is expected to match, not when it was produced.
+	staple := "none"
+	if len(state.OCSPResponse) > 0 {
+		staple = "present, issuer unknown"
+		if issuer != nil {
+			st, err := stapleStatus(state.OCSPResponse, leaf, issuer)
+			if err != nil {
+				staple = "invalid"
+			} else {
+				staple = st
+			}
+		}
+	}
+
+	leafSum := sha256.Sum256(leaf.Raw)
+	return []string{
+		"sha256 " + hex.EncodeToString(leafSum[:]),
+		strings.Join(chain, " <- "),
+		leaf.NotAfter.UTC().Format(time.RFC3339),
+		strings.Join(sans, ", "),
+		staple,
+		verification,
+	}, nil
+}
+
+// stapleStatus returns the status a stapled OCSP response gives leaf, noting when it is past its next update.
+func stapleStatus(der []byte, leaf, issuer *x509.Certificate) (string, error) {
+	id, err := newOCSPCertID(leaf, issuer)
+	if err != nil {
+		return "", err
+	}
+	st, err := parseOCSPResponse(der, id, issuer)
+	if err != nil {
+		return "", err
+	}
+	if st.stale() {
+		return st.status + " (stale)", nil
+	}
+	return st.status, nil
+}
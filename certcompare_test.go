@@ -0,0 +1,39 @@
+package main
+
+import (
+	"crypto/x509"
+	"math/big"
+	"testing"
+	"time"
+)
+
+func TestStapleStatus(t *testing.T) {
+	ca := newTestCA(t, "Test CA")
+	otherCA := newTestCA(t, "Other CA")
+	leaf := newTestCert(t, &x509.Certificate{SerialNumber: big.NewInt(1000)}, ca)
+	id, err := newOCSPCertID(leaf.cert, ca.cert)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name    string
+		answer  ocspAnswer
+		want    string
+		wantErr bool
+	}{
+		{name: "current", answer: ocspAnswer{status: 0, signer: ca, nextUpdate: time.Now().Add(time.Hour)}, want: "good"},
+		{name: "no next update", answer: ocspAnswer{status: 2, signer: ca}, want: "unknown"},
+		{name: "stale", answer: ocspAnswer{status: 1, signer: ca, thisUpdate: time.Now().Add(-48 * time.Hour), nextUpdate: time.Now().Add(-time.Hour)}, want: "revoked (stale)"},
+		{name: "bad signature", answer: ocspAnswer{status: 0, signer: otherCA}, wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := stapleStatus(marshalOCSPResponse(t, id, tt.answer), leaf.cert, ca.cert)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("%s: error = %v, want error %v", tt.name, err, tt.wantErr)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("%s: stapleStatus() = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
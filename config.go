@@ -0,0 +1,118 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// configFile holds named profiles, each a set of defaults for one environment. It is JSON because the standard
+// library has no YAML parser and the tool has no dependencies. For example:
+//
+//	{
+//	  "profiles": {
+//	    "staging": {
+//	      "flags": {"cacert": "/etc/ssl/staging-ca.pem", "connect-timeout": "2s", "retries": 2},
+//	      "headers": {"X-Debug": "1"},
+//	      "overrides": {"api.example.com": "10.1.0.5", "www.example.com:443": "10.1.0.6:8443"}
+//	    }
+//	  }
+//	}
+type configFile struct {
+	Profiles map[string]profile `json:"profiles"`
+}
+
+// profile sets flags by name, default request headers and dial overrides. Overrides are keyed by host:port or by
+// bare host, which applies to every port.
+type profile struct {
+	Flags     map[string]any    `json:"flags"`
+	Headers   map[string]string `json:"headers"`
+	Overrides map[string]string `json:"overrides"`
+}
+
+// flagEnv names the environment variables flags take their defaults from. A variable that is set beats the profile.
+var flagEnv = map[string]string{
+	"keylog":  "SSLKEYLOGFILE",
+	"profile": "GOTEST_PROFILE",
+}
+
+func defaultConfigPath() string {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(dir, "gotest", "config.json")
+}
+
+// applyProfile fills in opts from the profile named by -profile. Flags given on the command line win over environment
+// variables, which win over the profile, which wins over the built-in defaults. Profile headers come before those of
+// -H, so -H replaces a profile header of the same name.
+func applyProfile(opts *options) error {
+	if opts.profile == "" {
+		return nil
+	}
+	if opts.configFile == "" {
+		return newError(errBadInput, "-profile needs -config, no user config directory is known")
+	}
+	data, err := os.ReadFile(opts.configFile)
+	if err != nil {
+		return newError(errBadInput, "reading config failed: %v", err)
+	}
+	var cfg configFile
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&cfg); err != nil {
+		return newError(errBadInput, "parsing config %s failed: %v", opts.configFile, err)
+	}
+	p, ok := cfg.Profiles[opts.profile]
+	if !ok {
+		names := slices.Sorted(maps.Keys(cfg.Profiles))
+		return newError(errBadInput, "no profile %q in %s, it has: %s", opts.profile, opts.configFile, strings.Join(names, ", "))
+	}
+
+	given := map[string]bool{}
+	flag.Visit(func(f *flag.Flag) { given[f.Name] = true })
+	for _, name := range slices.Sorted(maps.Keys(p.Flags)) {
+		if flag.Lookup(name) == nil {
+			return newError(errBadInput, "profile %q sets unknown flag %q", opts.profile, name)
+		}
+		if name == "profile" || name == "config" {
+			return newError(errBadInput, "profile %q cannot set -%s", opts.profile, name)
+		}
+		if env := flagEnv[name]; given[name] || env != "" && os.Getenv(env) != "" {
+			continue
+		}
+		var value string
+		switch v := p.Flags[name].(type) {
+		case string:
+			value = v
+		case json.Number:
+			value = v.String()
+		case bool:
+			value = strconv.FormatBool(v)
+		default:
+			return newError(errBadInput, "profile %q sets flag %q to %v, want a string, number or boolean", opts.profile, name, v)
+		}
+		if err := flag.Set(name, value); err != nil {
+			return newError(errBadInput, "profile %q sets flag %q: %v", opts.profile, name, err)
+		}
+	}
+
+	var headers []string
+	for _, name := range slices.Sorted(maps.Keys(p.Headers)) {
+		headers = append(headers, name+": "+p.Headers[name])
+	}
+	opts.headers = append(headers, opts.headers...)
+	opts.overrides = dialOverrides{}
+	for k, v := range p.Overrides {
+		opts.overrides.set(k, v)
+	}
+	return nil
+}
@@ -0,0 +1,183 @@
+package main
+
+import (
+	"flag"
+	"maps"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+const testConfig = `{
+  "profiles": {
+    "staging": {
+      "flags": {"retries": 2, "L": true, "connect-timeout": "2s", "keylog": "/tmp/profile-keys"},
+      "headers": {"X-Env": "staging", "X-Debug": "1"},
+      "overrides": {"API.Example.com.": "10.1.0.5"}
+    },
+    "unknown-flag": {"flags": {"no-such-flag": 1}},
+    "bad-value": {"flags": {"retries": "many"}},
+    "bad-type": {"flags": {"retries": [1]}},
+    "sets-config": {"flags": {"config": "/etc/other.json"}}
+  }
+}`
+
+// profileOptions parses args as the command line with env set, and applies the profile it selects from testConfig.
+func profileOptions(t *testing.T, env map[string]string, args ...string) (options, error) {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "config.json")
+	if err := os.WriteFile(path, []byte(testConfig), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"SSLKEYLOGFILE", "GOTEST_PROFILE"} {
+		t.Setenv(name, env[name])
+	}
+	old := flag.CommandLine
+	t.Cleanup(func() { flag.CommandLine = old })
+	flag.CommandLine = flag.NewFlagSet("gotest", flag.ContinueOnError)
+	var opts options
+	defineFlags(&opts)
+	if err := flag.CommandLine.Parse(append([]string{"-config", path}, args...)); err != nil {
+		t.Fatal(err)
+	}
+	err := applyProfile(&opts)
+	return opts, err
+}
+
+func TestApplyProfile(t *testing.T) {
+	tests := []struct {
+		name        string
+		env         map[string]string
+		args        []string
+		wantRetries int
+		wantL       bool
+		wantKeyLog  string
+		wantHeaders []string
+	}{
+		{
+			name:        "no profile",
+			args:        []string{"-retries", "1"},
+			wantRetries: 1,
+		},
+		{
+			name:        "profile over defaults",
+			args:        []string{"-profile", "staging"},
+			wantRetries: 2,
+			wantL:       true,
+			wantKeyLog:  "/tmp/profile-keys",
+			wantHeaders: []string{"X-Debug: 1", "X-Env: staging"},
+		},
+		{
+			name:        "profile from the environment",
+			env:         map[string]string{"GOTEST_PROFILE": "staging"},
+			wantRetries: 2,
+			wantL:       true,
+			wantKeyLog:  "/tmp/profile-keys",
+			wantHeaders: []string{"X-Debug: 1", "X-Env: staging"},
+		},
+		{
+			name:        "command line over profile",
+			args:        []string{"-profile", "staging", "-retries", "0", "-L=false", "-H", "X-Env: prod"},
+			wantKeyLog:  "/tmp/profile-keys",
+			wantHeaders: []string{"X-Debug: 1", "X-Env: staging", "X-Env: prod"},
+		},
+		{
+			name:        "environment over profile",
+			env:         map[string]string{"SSLKEYLOGFILE": "/tmp/env-keys"},
+			args:        []string{"-profile", "staging"},
+			wantRetries: 2,
+			wantL:       true,
+			wantKeyLog:  "/tmp/env-keys",
+			wantHeaders: []string{"X-Debug: 1", "X-Env: staging"},
+		},
+		{
+			name:        "command line over environment",
+			env:         map[string]string{"SSLKEYLOGFILE": "/tmp/env-keys"},
+			args:        []string{"-profile", "staging", "-keylog", "/tmp/cli-keys"},
+			wantRetries: 2,
+			wantL:       true,
+			wantKeyLog:  "/tmp/cli-keys",
+			wantHeaders: []string{"X-Debug: 1", "X-Env: staging"},
+		},
+	}
+	for _, tt := range tests {
+		opts, err := profileOptions(t, tt.env, tt.args...)
+		if err != nil {
+			t.Errorf("%s: applyProfile() = %v", tt.name, err)
+			continue
+		}
+		if opts.retries != tt.wantRetries || opts.followRedirects != tt.wantL || opts.keyLogFile != tt.wantKeyLog {
+			t.Errorf("%s: retries %d, -L %v, keylog %q; want %d, %v, %q", tt.name, opts.retries, opts.followRedirects, opts.keyLogFile, tt.wantRetries, tt.wantL, tt.wantKeyLog)
+		}
+		if !slices.Equal(opts.headers, tt.wantHeaders) {
+			t.Errorf("%s: headers = %q, want %q", tt.name, opts.headers, tt.wantHeaders)
+		}
+		// Flags the profile does not set keep their defaults.
+		if opts.retryBackoff != 200*time.Millisecond {
+			t.Errorf("%s: -retry-backoff = %v, want the default", tt.name, opts.retryBackoff)
+		}
+		if tt.wantHeaders == nil {
+			continue
+		}
+		if want := (dialOverrides{"api.example.com": "10.1.0.5"}); !maps.Equal(opts.overrides, want) {
+			t.Errorf("%s: overrides = %v, want %v", tt.name, opts.overrides, want)
+		}
+		if d := opts.connectTimeout; d != 2*time.Second {
+			t.Errorf("%s: -connect-timeout = %v, want 2s from the profile", tt.name, d)
+		}
+		// A later -H replaces the profile header of the same name.
+		opts.keyLogFile = ""
+		s, err := newSession(opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got, want := s.header.Get("X-Env"), tt.wantHeaders[len(tt.wantHeaders)-1][len("X-Env: "):]; got != want {
+			t.Errorf("%s: X-Env sent as %q, want %q", tt.name, got, want)
+		}
+		s.close()
+	}
+}
+
+func TestApplyProfileErrors(t *testing.T) {
+	tests := []struct {
+		profile string
+		want    string
+	}{
+		{profile: "production", want: `no profile "production"`},
+		{profile: "unknown-flag", want: `unknown flag "no-such-flag"`},
+		{profile: "bad-value", want: `sets flag "retries"`},
+		{profile: "bad-type", want: "want a string, number or boolean"},
+		{profile: "sets-config", want: "cannot set -config"},
+	}
+	for _, tt := range tests {
+		_, err := profileOptions(t, nil, "-profile", tt.profile)
+		if kindOf(err) != errBadInput || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("%s: applyProfile() = %v, want bad input saying %s", tt.profile, err, tt.want)
+		}
+	}
+}
+
+func TestIPArgumentOverridesProfile(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(helloHandler))
+	defer srv.Close()
+	_, port, _ := net.SplitHostPort(srv.Listener.Addr().String())
+	url := "http://profiled.test:" + port + "/"
+	// The profile points the host at a port nothing listens on.
+	opts := options{count: 1, overrides: dialOverrides{"profiled.test": "127.0.0.1:1"}}
+
+	var err error
+	captureStdout(t, func() { err = runArgs(t, opts, url) })
+	if kindOf(err) != errRefused {
+		t.Errorf("run() through the profile override = %v, want connection refused", err)
+	}
+	out := captureStdout(t, func() { err = runArgs(t, opts, url, "127.0.0.1") })
+	if err != nil || !strings.Contains(out, "Status: 200 OK") {
+		t.Errorf("run() with an ip argument = %v, printed:\n%s", err, out)
+	}
+}
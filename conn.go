@@ -0,0 +1,225 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/http/httptrace"
+	"slices"
+	"sync"
+)
+
+type connConfigKey struct{}
+
+// connConfig holds the per-session socket settings and observers that the shared transport's dial functions apply to
+// the connections they create. It travels in the request context, like dialOverrides, so one transport can serve
+// every session.
+type connConfig struct {
+	dial     dialOptions
+	tcpConns *tcpConnLog
+	verbose  *verboseLog
+	h2Frames *verboseLog
+	pins     *pinStore
+
+	// tlsProtos and spareConns belong to tlsProtocol and dialTappedTLS.
+	tlsMu      sync.Mutex
+	tlsProtos  map[tlsConnKey]string
+	spareConns map[tlsConnKey][]*tappedTLSConn
+}
+
+func withConnConfig(ctx context.Context, cc *connConfig) context.Context {
+	return context.WithValue(ctx, connConfigKey{}, cc)
+}
+
+// connConfigFromContext returns the context's connConfig. The result may be nil; its methods treat nil as "observe
+// nothing".
+func connConfigFromContext(ctx context.Context) *connConfig {
+	cc, _ := ctx.Value(connConfigKey{}).(*connConfig)
+	return cc
+}
+
+func (cc *connConfig) logf(format string, args ...any) {
+	if cc != nil && cc.verbose != nil {
+		cc.verbose.printf(format, args...)
+	}
+}
+
+// tapFor returns the observer for a connection speaking the given ALPN protocol ("" for cleartext HTTP/1.1), or nil
+// when nothing wants to see its bytes.
+func (cc *connConfig) tapFor(proto string) connTap {
+	if cc == nil {
+		return nil
+	}
+	if cc.verbose != nil && proto != "h2" {
+		return newH1HeaderDump(cc.verbose)
+	}
+	if cc.h2Frames != nil && proto == "h2" {
+		return newH2FrameLog(cc.h2Frames)
+	}
+	return nil
+}
+
+// wrap observes a cleartext connection, which speaks HTTP/1.1 unless proto says otherwise.
+func (cc *connConfig) wrap(conn net.Conn, proto string) net.Conn {
+	if tap := cc.tapFor(proto); tap != nil {
+		return &tappedConn{Conn: conn, tap: tap}
+	}
+	return conn
+}
+
+// connTap sees the plaintext bytes of a connection in both directions. Reads and writes happen on different
+// goroutines, so implementations must do their own locking.
+type connTap interface {
+	read(p []byte)
+	wrote(p []byte)
+}
+
+type tappedConn struct {
+	net.Conn
+	tap connTap
+}
+
+func (c *tappedConn) Read(p []byte) (int, error) {
+	n, err := c.Conn.Read(p)
+	if n > 0 {
+		c.tap.read(p[:n])
+	}
+	return n, err
+}
+
+func (c *tappedConn) Write(p []byte) (int, error) {
+	n, err := c.Conn.Write(p)
+	if n > 0 {
+		c.tap.wrote(p[:n])
+	}
+	return n, err
+}
+
+// tappedTLSConn is a TLS connection that dialTappedTLS has already handshaken. It deliberately hides the *tls.Conn:
+// given one, the transport drives HTTP/2 over the connection itself, beyond the reach of the tap. The tapped transports
+// therefore see a plain net.Conn and are told the protocol up front instead.
+type tappedTLSConn struct {
+	tappedConn
+	state tls.ConnectionState
+}
+
+// negotiatedProtocol returns the ALPN protocol of conn, and whether conn is a TLS connection at all.
+func negotiatedProtocol(conn net.Conn) (string, bool) {
+	switch c := conn.(type) {
+	case *tappedTLSConn:
+		return c.state.NegotiatedProtocol, true
+	case interface{ ConnectionState() tls.ConnectionState }:
+		return c.ConnectionState().NegotiatedProtocol, true
+	}
+	return "", false
+}
+
+// tapsTLS reports whether TLS connections need to be set up by dialTappedTLS so their plaintext can be observed.
+func (cc *connConfig) tapsTLS() bool {
+	return cc != nil && (cc.verbose != nil || cc.h2Frames != nil)
+}
+
+type tlsConnKey struct {
+	addr, target string
+}
+
+// tlsProtocol returns the protocol that the server at addr negotiates when offered both HTTP/2 and HTTP/1.1, so the
+// request can be routed to the tapped transport that speaks it. The first request to an address finds out with a
+// handshake of its own; that connection is kept for the transport to pick up rather than wasted.
+func (cc *connConfig) tlsProtocol(ctx context.Context, addr string) (string, error) {
+	target, _ := dialOverridesFromContext(ctx).lookup(addr)
+	key := tlsConnKey{addr, target}
+	cc.tlsMu.Lock()
+	proto, ok := cc.tlsProtos[key]
+	cc.tlsMu.Unlock()
+	if ok {
+		return proto, nil
+	}
+	conn, err := cc.handshakeTLS(ctx, "tcp", addr, []string{"h2", "http/1.1"})
+	if err != nil {
+		return "", err
+	}
+	proto = conn.state.NegotiatedProtocol
+	cc.tlsMu.Lock()
+	defer cc.tlsMu.Unlock()
+	if cc.tlsProtos == nil {
+		cc.tlsProtos = map[tlsConnKey]string{}
+		cc.spareConns = map[tlsConnKey][]*tappedTLSConn{}
+	}
+	cc.tlsProtos[key] = proto
+	cc.spareConns[key] = append(cc.spareConns[key], conn)
+	return proto, nil
+}
+
+// dialTappedTLS returns a handshaken, tapped TLS connection to addr that speaks proto, preferring one left over from
+// tlsProtocol.
+func (cc *connConfig) dialTappedTLS(ctx context.Context, network, addr, proto string) (net.Conn, error) {
+	target, _ := dialOverridesFromContext(ctx).lookup(addr)
+	key := tlsConnKey{addr, target}
+	cc.tlsMu.Lock()
+	for i, conn := range cc.spareConns[key] {
+		if (conn.state.NegotiatedProtocol == "h2") == (proto == "h2") {
+			cc.spareConns[key] = slices.Delete(cc.spareConns[key], i, i+1)
+			cc.tlsMu.Unlock()
+			return conn, nil
+		}
+	}
+	cc.tlsMu.Unlock()
+
+	conn, err := cc.handshakeTLS(ctx, network, addr, []string{proto})
+	if err != nil {
+		return nil, err
+	}
+	if proto == "h2" && conn.state.NegotiatedProtocol != "h2" {
+		conn.Close()
+		return nil, fmt.Errorf("%s no longer negotiates HTTP/2", addr)
+	}
+	return conn, nil
+}
+
+// handshakeTLS dials addr and completes a TLS handshake offering nextProtos. The transport does not know about the
+// handshake, so the httptrace events it would have emitted are emitted here.
+func (cc *connConfig) handshakeTLS(ctx context.Context, network, addr string, nextProtos []string) (*tappedTLSConn, error) {
+	conn, cfg, err := dialTLSConfig(ctx, network, addr)
+	if err != nil {
+		return nil, err
+	}
+	cfg.NextProtos = nextProtos
+	tc := tls.Client(conn, cfg)
+	trace := httptrace.ContextClientTrace(ctx)
+	if trace != nil && trace.TLSHandshakeStart != nil {
+		trace.TLSHandshakeStart()
+	}
+	err = tc.HandshakeContext(ctx)
+	if trace != nil && trace.TLSHandshakeDone != nil {
+		trace.TLSHandshakeDone(tc.ConnectionState(), err)
+	}
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	state := tc.ConnectionState()
+	c := &tappedTLSConn{tappedConn: tappedConn{Conn: tc, tap: cc.tapFor(state.NegotiatedProtocol)}, state: state}
+	if c.tap == nil {
+		c.tap = nopTap{}
+	}
+	return c, nil
+}
+
+// closeSpareConns closes the connections tlsProtocol handshook that no request went on to use.
+func (cc *connConfig) closeSpareConns() {
+	cc.tlsMu.Lock()
+	defer cc.tlsMu.Unlock()
+	for key, conns := range cc.spareConns {
+		for _, conn := range conns {
+			conn.Close()
+		}
+		delete(cc.spareConns, key)
+	}
+}
+
+type nopTap struct{}
+
+func (nopTap) read([]byte)  {}
+func (nopTap) wrote([]byte) {}
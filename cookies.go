@@ -0,0 +1,262 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const netscapeCookieHeader = "# Netscape HTTP Cookie File"
+
+// jarEntry is a single stored cookie, keyed by domain, path and name.
+type jarEntry struct {
+	Domain   string
+	HostOnly bool
+	Path     string
+	Secure   bool
+	HttpOnly bool
+	Expires  time.Time // zero for session cookies
+	Name     string
+	Value    string
+}
+
+func (e *jarEntry) key() string {
+	return e.Domain + ";" + e.Path + ";" + e.Name
+}
+
+func (e *jarEntry) expired(now time.Time) bool {
+	return !e.Expires.IsZero() && !e.Expires.After(now)
+}
+
+// cookieJar is an http.CookieJar that, unlike net/http/cookiejar, can enumerate its
+// contents so they can be written back to a Netscape-format cookie file.
+//
+// Cookies are always scoped to the request URL host, never to the dialed address, so a
+// dial override does not change which cookies are sent or stored.
+type cookieJar struct {
+	mu      sync.Mutex
+	entries map[string]*jarEntry
+}
+
+func newCookieJar() *cookieJar {
+	return &cookieJar{entries: make(map[string]*jarEntry)}
+}
+
+func (j *cookieJar) SetCookies(u *url.URL, cookies []*http.Cookie) {
+	host := canonicalCookieHost(u.Hostname())
+	now := time.Now()
+
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	for _, c := range cookies {
+		e := &jarEntry{
+			Domain:   host,
+			HostOnly: true,
+			Path:     c.Path,
+			Secure:   c.Secure,
+			HttpOnly: c.HttpOnly,
+			Name:     c.Name,
+			Value:    c.Value,
+		}
+		if c.Domain != "" {
+			domain := canonicalCookieHost(strings.TrimPrefix(c.Domain, "."))
+			if !domainMatch(host, domain) {
+				continue
+			}
+			e.Domain = domain
+			e.HostOnly = domain == host && net.ParseIP(host) != nil
+		}
+		if !strings.HasPrefix(e.Path, "/") {
+			e.Path = defaultCookiePath(u.Path)
+		}
+		switch {
+		case c.MaxAge < 0:
+			delete(j.entries, e.key())
+			continue
+		case c.MaxAge > 0:
+			e.Expires = now.Add(time.Duration(c.MaxAge) * time.Second)
+		case !c.Expires.IsZero():
+			e.Expires = c.Expires
+		}
+		if e.expired(now) {
+			delete(j.entries, e.key())
+			continue
+		}
+		j.entries[e.key()] = e
+	}
+}
+
+func (j *cookieJar) Cookies(u *url.URL) []*http.Cookie {
+	host := canonicalCookieHost(u.Hostname())
+	secure := u.Scheme == "https"
+	path := u.Path
+	if path == "" {
+		path = "/"
+	}
+	now := time.Now()
+
+	j.mu.Lock()
+	var matched []*jarEntry
+	for k, e := range j.entries {
+		if e.expired(now) {
+			delete(j.entries, k)
+			continue
+		}
+		if e.HostOnly && host != e.Domain || !e.HostOnly && !domainMatch(host, e.Domain) {
+			continue
+		}
+		if e.Secure && !secure || !pathMatch(path, e.Path) {
+			continue
+		}
+		matched = append(matched, e)
+	}
+	j.mu.Unlock()
+
+	// Longer paths first, as RFC 6265 section 5.4 recommends.
+	sort.SliceStable(matched, func(a, b int) bool {
+		if len(matched[a].Path) != len(matched[b].Path) {
+			return len(matched[a].Path) > len(matched[b].Path)
+		}
+		return matched[a].Name < matched[b].Name
+	})
+	cookies := make([]*http.Cookie, 0, len(matched))
+	for _, e := range matched {
+		cookies = append(cookies, &http.Cookie{Name: e.Name, Value: e.Value})
+	}
+	return cookies
+}
+
+// Load reads cookies from a Netscape-format cookie file as written by curl and wget.
+// A missing file is not an error so the same path can be used to start a new session.
+func (j *cookieJar) Load(path string) error {
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	now := time.Now()
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	scanner := bufio.NewScanner(f)
+	for lineNo := 1; scanner.Scan(); lineNo++ {
+		// Only the line ending is trimmed: an empty value leaves a trailing tab that is part of the record.
+		line := strings.TrimRight(scanner.Text(), "\r\n")
+		httpOnly := false
+		if rest, ok := strings.CutPrefix(line, "#HttpOnly_"); ok {
+			line, httpOnly = rest, true
+		}
+		if strings.TrimSpace(line) == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.Split(line, "\t")
+		if len(fields) != 7 {
+			return fmt.Errorf("%s:%d: expected 7 tab-separated fields, got %d", path, lineNo, len(fields))
+		}
+		expiry, err := strconv.ParseInt(fields[4], 10, 64)
+		if err != nil {
+			return fmt.Errorf("%s:%d: bad expiry %q", path, lineNo, fields[4])
+		}
+		e := &jarEntry{
+			Domain:   canonicalCookieHost(strings.TrimPrefix(fields[0], ".")),
+			HostOnly: strings.EqualFold(fields[1], "FALSE"),
+			Path:     fields[2],
+			Secure:   strings.EqualFold(fields[3], "TRUE"),
+			HttpOnly: httpOnly,
+			Name:     fields[5],
+			Value:    fields[6],
+		}
+		if expiry > 0 {
+			e.Expires = time.Unix(expiry, 0)
+		}
+		if e.expired(now) {
+			continue
+		}
+		j.entries[e.key()] = e
+	}
+	return scanner.Err()
+}
+
+// Save writes every unexpired cookie, session cookies included, to a Netscape-format file.
+func (j *cookieJar) Save(path string) error {
+	now := time.Now()
+	j.mu.Lock()
+	entries := make([]*jarEntry, 0, len(j.entries))
+	for _, e := range j.entries {
+		if !e.expired(now) {
+			entries = append(entries, e)
+		}
+	}
+	j.mu.Unlock()
+	sort.Slice(entries, func(a, b int) bool { return entries[a].key() < entries[b].key() })
+
+	var b strings.Builder
+	b.WriteString(netscapeCookieHeader + "\n\n")
+	for _, e := range entries {
+		domain := e.Domain
+		if !e.HostOnly {
+			domain = "." + domain
+		}
+		if e.HttpOnly {
+			domain = "#HttpOnly_" + domain
+		}
+		var expiry int64
+		if !e.Expires.IsZero() {
+			expiry = e.Expires.Unix()
+		}
+		fmt.Fprintf(&b, "%s\t%s\t%s\t%s\t%d\t%s\t%s\n",
+			domain, netscapeBool(!e.HostOnly), e.Path, netscapeBool(e.Secure), expiry, e.Name, e.Value)
+	}
+	return os.WriteFile(path, []byte(b.String()), 0o600)
+}
+
+func netscapeBool(b bool) string {
+	if b {
+		return "TRUE"
+	}
+	return "FALSE"
+}
+
+func canonicalCookieHost(host string) string {
+	return strings.ToLower(strings.TrimSuffix(host, "."))
+}
+
+// domainMatch reports whether host is domain or a subdomain of it. IP addresses only match themselves.
+func domainMatch(host, domain string) bool {
+	if host == domain {
+		return true
+	}
+	if net.ParseIP(host) != nil {
+		return false
+	}
+	return strings.HasSuffix(host, "."+domain)
+}
+
+func pathMatch(reqPath, cookiePath string) bool {
+	if reqPath == cookiePath {
+		return true
+	}
+	if !strings.HasPrefix(reqPath, cookiePath) {
+		return false
+	}
+	return strings.HasSuffix(cookiePath, "/") || reqPath[len(cookiePath)] == '/'
+}
+
+func defaultCookiePath(reqPath string) string {
+	i := strings.LastIndex(reqPath, "/")
+	if i <= 0 {
+		return "/"
+	}
+	return reqPath[:i]
+}
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestCookieJarSaveLoadRoundTrip(t *testing.T) {
+	u, _ := url.Parse("https://www.example.com/app/login")
+	jar := newCookieJar()
+	jar.SetCookies(u, []*http.Cookie{
+		{Name: "session", Value: "abc", Path: "/", Secure: true, HttpOnly: true},
+		{Name: "empty", Value: "", Path: "/"},
+		{Name: "shared", Value: "1", Domain: ".example.com", MaxAge: 3600},
+		{Name: "scoped", Value: "x"},
+	})
+
+	path := filepath.Join(t.TempDir(), "jar.txt")
+	if err := jar.Save(path); err != nil {
+		t.Fatal(err)
+	}
+	loaded := newCookieJar()
+	if err := loaded.Load(path); err != nil {
+		t.Fatalf("loading saved jar: %v", err)
+	}
+	if len(loaded.entries) != len(jar.entries) {
+		t.Fatalf("loaded %d cookies, saved %d", len(loaded.entries), len(jar.entries))
+	}
+	for k, want := range jar.entries {
+		got, ok := loaded.entries[k]
+		if !ok {
+			t.Errorf("cookie %s lost", k)
+			continue
+		}
+		// The file stores expiry in whole seconds.
+		if !got.Expires.Equal(want.Expires.Truncate(time.Second)) {
+			t.Errorf("cookie %s expires %v, want %v", k, got.Expires, want.Expires)
+		}
+		got.Expires, want.Expires = time.Time{}, time.Time{}
+		if *got != *want {
+			t.Errorf("cookie %s = %+v, want %+v", k, *got, *want)
+		}
+	}
+}
+
+func TestCookieJarLoad(t *testing.T) {
+	tests := []struct {
+		name    string
+		file    string
+		want    []jarEntry
+		wantErr bool
+	}{
+		{
+			name: "host only",
+			file: "www.example.com\tFALSE\t/\tTRUE\t0\ta\tb\n",
+			want: []jarEntry{{Domain: "www.example.com", HostOnly: true, Path: "/", Secure: true, Name: "a", Value: "b"}},
+		},
+		{
+			name: "domain and http only",
+			file: "#HttpOnly_.Example.com\tTRUE\t/x\tFALSE\t0\ta\tb\r\n",
+			want: []jarEntry{{Domain: "example.com", Path: "/x", HttpOnly: true, Name: "a", Value: "b"}},
+		},
+		{
+			name: "empty value",
+			file: "example.com\tFALSE\t/\tFALSE\t0\ta\t\n",
+			want: []jarEntry{{Domain: "example.com", HostOnly: true, Path: "/", Name: "a"}},
+		},
+		{
+			name: "comments and blank lines",
+			file: netscapeCookieHeader + "\n\n  \n# comment\n",
+		},
+		{
+			name: "expired",
+			file: "example.com\tFALSE\t/\tFALSE\t1\ta\tb\n",
+		},
+		{
+			name:    "too few fields",
+			file:    "example.com\tFALSE\t/\tFALSE\t0\ta\n",
+			wantErr: true,
+		},
+		{
+			name:    "bad expiry",
+			file:    "example.com\tFALSE\t/\tFALSE\tsoon\ta\tb\n",
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "jar.txt")
+			if err := os.WriteFile(path, []byte(tt.file), 0o600); err != nil {
+				t.Fatal(err)
+			}
+			jar := newCookieJar()
+			err := jar.Load(path)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Load() error = %v, want error %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if len(jar.entries) != len(tt.want) {
+				t.Fatalf("loaded %d cookies, want %d", len(jar.entries), len(tt.want))
+			}
+			for _, want := range tt.want {
+				got, ok := jar.entries[want.key()]
+				if !ok || *got != want {
+					t.Errorf("cookie %s = %+v, want %+v", want.key(), got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestCookieJarLoadMissingFile(t *testing.T) {
+	if err := newCookieJar().Load(filepath.Join(t.TempDir(), "none")); err != nil {
+		t.Errorf("Load() of a missing file = %v, want nil", err)
+	}
+}
+
+func TestDomainMatch(t *testing.T) {
+	tests := []struct {
+		host, domain string
+		want         bool
+	}{
+		{"example.com", "example.com", true},
+		{"www.example.com", "example.com", true},
+		{"badexample.com", "example.com", false},
+		{"example.com", "www.example.com", false},
+		{"10.0.0.1", "0.0.1", false},
+		{"10.0.0.1", "10.0.0.1", true},
+	}
+	for _, tt := range tests {
+		if got := domainMatch(tt.host, tt.domain); got != tt.want {
+			t.Errorf("domainMatch(%q, %q) = %v, want %v", tt.host, tt.domain, got, tt.want)
+		}
+	}
+}
+
+func TestPathMatch(t *testing.T) {
+	tests := []struct {
+		reqPath, cookiePath string
+		want                bool
+	}{
+		{"/", "/", true},
+		{"/app", "/app", true},
+		{"/app/login", "/app", true},
+		{"/app/login", "/app/", true},
+		{"/application", "/app", false},
+		{"/", "/app", false},
+	}
+	for _, tt := range tests {
+		if got := pathMatch(tt.reqPath, tt.cookiePath); got != tt.want {
+			t.Errorf("pathMatch(%q, %q) = %v, want %v", tt.reqPath, tt.cookiePath, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,164 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+)
+
+// corsSimpleMethods never need to be listed in Access-Control-Allow-Methods.
+var corsSimpleMethods = []string{http.MethodGet, http.MethodHead, http.MethodPost}
+
+// corsRequest is the cross-origin request a browser on origin is about to make. Headers are the non-safelisted
+// request header names, which the browser announces in Access-Control-Request-Headers.
+type corsRequest struct {
+	origin      string
+	method      string
+	headers     []string
+	credentials bool
+}
+
+// checkCORS sends the preflight a browser would send for cr, explains whether the browser would let the request
+// through and, with actual, sends the request itself and checks that its response may be read by the page.
+func (s *session) checkCORS(urlStr string, overrides dialOverrides, target string, cr corsRequest, actual bool) error {
+	fmt.Printf("CORS check for %s %s from origin %s", cr.method, urlStr, cr.origin)
+	if target != "" {
+		fmt.Printf(" via %s", target)
+	}
+	fmt.Printf("\n")
+	if slices.Contains(corsSimpleMethods, cr.method) && len(cr.headers) == 0 {
+		fmt.Printf("Note: a browser would not preflight this request, only the actual response is checked by it\n")
+	}
+
+	// Browsers send the preflight without credentials or any of the page's headers.
+	req, err := http.NewRequestWithContext(withBareRequest(context.Background()), http.MethodOptions, urlStr, nil)
+	if err != nil {
+		return newError(errBadInput, "building request failed: %v", err)
+	}
+	req.Header.Set("Origin", cr.origin)
+	req.Header.Set("Access-Control-Request-Method", cr.method)
+	if len(cr.headers) > 0 {
+		req.Header.Set("Access-Control-Request-Headers", strings.ToLower(strings.Join(cr.headers, ",")))
+	}
+	resp, _, err := s.fetch(req, overrides)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Preflight: %s\n", resp.Status)
+	printCORSHeaders(resp)
+	problems := cr.checkPreflight(resp)
+	if len(problems) > 0 {
+		return corsRejected("preflight", problems)
+	}
+	fmt.Printf("Preflight: browser would allow the request\n")
+	if !actual {
+		return nil
+	}
+
+	req, err = http.NewRequest(cr.method, urlStr, nil)
+	if err != nil {
+		return newError(errBadInput, "building request failed: %v", err)
+	}
+	req.Header.Set("Origin", cr.origin)
+	for _, name := range cr.headers {
+		req.Header.Set(name, "gotest")
+	}
+	resp, body, err := s.fetch(req, overrides)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Actual request: %s (%d bytes)\n", resp.Status, len(body))
+	printCORSHeaders(resp)
+	if problems := cr.checkAllowOrigin(resp); len(problems) > 0 {
+		return corsRejected("actual response", problems)
+	}
+	if expose := resp.Header.Get("Access-Control-Expose-Headers"); expose != "" {
+		fmt.Printf("Readable headers besides the safelisted ones: %s\n", expose)
+	}
+	fmt.Printf("Actual request: page would be able to read the response\n")
+	return nil
+}
+
+func printCORSHeaders(resp *http.Response) {
+	names := make([]string, 0, len(resp.Header))
+	for name := range resp.Header {
+		if strings.HasPrefix(name, "Access-Control-") || name == "Vary" {
+			names = append(names, name)
+		}
+	}
+	slices.Sort(names)
+	for _, name := range names {
+		for _, v := range resp.Header[name] {
+			fmt.Printf("  %s: %s\n", name, v)
+		}
+	}
+}
+
+func corsRejected(stage string, problems []string) error {
+	fmt.Printf("%s: browser would reject the request because\n", strings.ToUpper(stage[:1])+stage[1:])
+	for _, p := range problems {
+		fmt.Printf("  - %s\n", p)
+	}
+	return newError(errCheckFailed, "CORS %s rejected: %s", stage, problems[0])
+}
+
+// checkPreflight applies the checks of the Fetch standard's CORS-preflight fetch to resp.
+func (cr corsRequest) checkPreflight(resp *http.Response) []string {
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return []string{fmt.Sprintf("preflight status %d is not 2xx", resp.StatusCode)}
+	}
+	problems := cr.checkAllowOrigin(resp)
+
+	methods := splitList(resp.Header.Get("Access-Control-Allow-Methods"))
+	switch {
+	case slices.Contains(methods, cr.method), slices.Contains(corsSimpleMethods, cr.method):
+	case slices.Contains(methods, "*") && !cr.credentials:
+	case slices.Contains(methods, "*"):
+		problems = append(problems, fmt.Sprintf("Access-Control-Allow-Methods \"*\" does not cover %s for credentialed requests", cr.method))
+	default:
+		problems = append(problems, fmt.Sprintf("method %s is not in Access-Control-Allow-Methods %q", cr.method, strings.Join(methods, ", ")))
+	}
+
+	allowed := splitList(strings.ToLower(resp.Header.Get("Access-Control-Allow-Headers")))
+	wildcard := slices.Contains(allowed, "*") && !cr.credentials
+	for _, name := range cr.headers {
+		name = strings.ToLower(name)
+		// The wildcard never covers Authorization.
+		if slices.Contains(allowed, name) || wildcard && name != "authorization" {
+			continue
+		}
+		problems = append(problems, fmt.Sprintf("request header %s is not in Access-Control-Allow-Headers", name))
+	}
+	if problems == nil {
+		if maxAge := resp.Header.Get("Access-Control-Max-Age"); maxAge != "" {
+			fmt.Printf("Preflight cached for %s seconds (browsers cap this, Chromium at 7200)\n", maxAge)
+		} else {
+			fmt.Printf("Preflight cached for 5 seconds, no Access-Control-Max-Age\n")
+		}
+	}
+	return problems
+}
+
+// checkAllowOrigin applies the CORS check to resp, which preflights and actual responses must both pass.
+func (cr corsRequest) checkAllowOrigin(resp *http.Response) []string {
+	values := resp.Header.Values("Access-Control-Allow-Origin")
+	var problems []string
+	switch {
+	case len(values) == 0:
+		return []string{"Access-Control-Allow-Origin is missing"}
+	case len(values) > 1 || strings.Contains(values[0], ","):
+		return []string{fmt.Sprintf("Access-Control-Allow-Origin must be a single value, got %q", strings.Join(values, ", "))}
+	case values[0] == "*" && cr.credentials:
+		problems = append(problems, "Access-Control-Allow-Origin \"*\" is not allowed for credentialed requests")
+	case values[0] != "*" && values[0] != cr.origin:
+		problems = append(problems, fmt.Sprintf("Access-Control-Allow-Origin %q does not match origin %q", values[0], cr.origin))
+	case values[0] != "*" && !slices.Contains(splitList(strings.ToLower(resp.Header.Get("Vary"))), "origin"):
+		fmt.Printf("Warning: Access-Control-Allow-Origin echoes the origin without Vary: Origin, caches may serve it to other origins\n")
+	}
+	if cr.credentials && resp.Header.Get("Access-Control-Allow-Credentials") != "true" {
+		problems = append(problems, "Access-Control-Allow-Credentials is not \"true\" for a credentialed request")
+	}
+	return problems
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+)
+
+func TestCheckCORSBarePreflight(t *testing.T) {
+	got := map[string]http.Header{}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got[r.Method] = r.Header.Clone()
+		w.Header().Set("Access-Control-Allow-Origin", "https://app.example")
+		w.Header().Set("Access-Control-Allow-Credentials", "true")
+		w.Header().Set("Access-Control-Allow-Methods", "PUT")
+		w.Header().Set("Access-Control-Allow-Headers", "x-request-id")
+		w.Header().Set("Vary", "Origin")
+	}))
+	defer srv.Close()
+
+	s, err := newSession(options{headers: []string{"Authorization: Bearer secret"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.close()
+	u, _ := url.Parse(srv.URL)
+	s.jar.SetCookies(u, []*http.Cookie{{Name: "session", Value: "abc"}})
+
+	cr := corsRequest{origin: "https://app.example", method: http.MethodPut, headers: []string{"X-Request-Id"}, credentials: true}
+	if err := s.checkCORS(srv.URL, nil, "", cr, true); err != nil {
+		t.Fatal(err)
+	}
+	preflight, actual := got[http.MethodOptions], got[http.MethodPut]
+	if preflight == nil || actual == nil {
+		t.Fatalf("server saw %v, want a preflight and the actual request", got)
+	}
+	for _, name := range []string{"Authorization", "Cookie"} {
+		if v := preflight.Get(name); v != "" {
+			t.Errorf("preflight sent %s: %s", name, v)
+		}
+		if actual.Get(name) == "" {
+			t.Errorf("actual request lacks %s", name)
+		}
+	}
+	if preflight.Get("Access-Control-Request-Headers") != "x-request-id" {
+		t.Errorf("preflight Access-Control-Request-Headers = %q", preflight.Get("Access-Control-Request-Headers"))
+	}
+}
+
+func TestCheckPreflight(t *testing.T) {
+	const origin = "https://app.example"
+	tests := []struct {
+		name     string
+		cr       corsRequest
+		status   int
+		header   http.Header
+		problems int
+	}{
+		{
+			name:   "allowed",
+			cr:     corsRequest{origin: origin, method: "DELETE", headers: []string{"X-Id"}},
+			header: http.Header{"Access-Control-Allow-Origin": {origin}, "Access-Control-Allow-Methods": {"GET, DELETE"}, "Access-Control-Allow-Headers": {"x-id"}, "Vary": {"Origin"}},
+		},
+		{
+			name:   "wildcards",
+			cr:     corsRequest{origin: origin, method: "PATCH", headers: []string{"X-Id"}},
+			header: http.Header{"Access-Control-Allow-Origin": {"*"}, "Access-Control-Allow-Methods": {"*"}, "Access-Control-Allow-Headers": {"*"}},
+		},
+		{
+			name:     "wildcards with credentials",
+			cr:       corsRequest{origin: origin, method: "PATCH", headers: []string{"X-Id"}, credentials: true},
+			header:   http.Header{"Access-Control-Allow-Origin": {"*"}, "Access-Control-Allow-Methods": {"*"}, "Access-Control-Allow-Headers": {"*"}, "Access-Control-Allow-Credentials": {"true"}},
+			problems: 3,
+		},
+		{
+			name:     "wildcard does not cover authorization",
+			cr:       corsRequest{origin: origin, method: "GET", headers: []string{"Authorization"}},
+			header:   http.Header{"Access-Control-Allow-Origin": {"*"}, "Access-Control-Allow-Headers": {"*"}},
+			problems: 1,
+		},
+		{
+			name:     "other origin and method",
+			cr:       corsRequest{origin: origin, method: "PUT"},
+			header:   http.Header{"Access-Control-Allow-Origin": {"https://other.example"}},
+			problems: 2,
+		},
+		{
+			name:     "several origins",
+			cr:       corsRequest{origin: origin, method: "GET"},
+			header:   http.Header{"Access-Control-Allow-Origin": {origin + ", https://other.example"}},
+			problems: 1,
+		},
+		{
+			name:     "not 2xx",
+			cr:       corsRequest{origin: origin, method: "GET"},
+			status:   http.StatusForbidden,
+			header:   http.Header{"Access-Control-Allow-Origin": {"*"}},
+			problems: 1,
+		},
+	}
+	for _, tt := range tests {
+		status := tt.status
+		if status == 0 {
+			status = http.StatusNoContent
+		}
+		problems := tt.cr.checkPreflight(&http.Response{StatusCode: status, Header: tt.header})
+		if len(problems) != tt.problems {
+			t.Errorf("%s: problems %q, want %d", tt.name, problems, tt.problems)
+		}
+	}
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"crypto/x509"
+	"encoding/asn1"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"time"
+)
+
+var (
+	oidSCTList     = asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 11129, 2, 4, 2}
+	oidOCSPSCTList = asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 11129, 2, 4, 5}
+)
+
+// sct is a Signed Certificate Timestamp (RFC 6962 section 3.2): a log's promise to publish the certificate.
+type sct struct {
+	version   byte
+	logID     []byte
+	timestamp time.Time
+	source    string
+}
+
+// parseSCTList parses a TLS-encoded SignedCertificateTimestampList into its raw SCTs.
+func parseSCTList(data []byte) ([][]byte, error) {
+	if len(data) < 2 || int(binary.BigEndian.Uint16(data)) != len(data)-2 {
+		return nil, errors.New("bad SCT list length")
+	}
+	var out [][]byte
+	for rest := data[2:]; len(rest) > 0; {
+		if len(rest) < 2 {
+			return nil, errors.New("truncated SCT list")
+		}
+		n := int(binary.BigEndian.Uint16(rest))
+		if len(rest) < 2+n {
+			return nil, errors.New("truncated SCT")
+		}
+		out = append(out, rest[2:2+n])
+		rest = rest[2+n:]
+	}
+	return out, nil
+}
+
+// parseSCTListExtension parses the SCT list carried in a certificate or OCSP extension, which wraps it in an
+// OCTET STRING.
+func parseSCTListExtension(value []byte) ([][]byte, error) {
+	var data []byte
+	if _, err := asn1.Unmarshal(value, &data); err != nil {
+		return nil, err
+	}
+	return parseSCTList(data)
+}
+
+func parseSCT(raw []byte, source string) (sct, error) {
+	// version (1), log ID (32), timestamp (8), then extensions and the signature.
+	if len(raw) < 41 {
+		return sct{}, errors.New("truncated SCT")
+	}
+	ms := int64(binary.BigEndian.Uint64(raw[33:41]))
+	return sct{version: raw[0], logID: raw[1:33], timestamp: time.UnixMilli(ms), source: source}, nil
+}
+
+// requiredSCTs is the number of SCTs from distinct logs Chrome and Safari want to see embedded in a certificate
+// with the given validity period. SCTs delivered in the TLS handshake or stapled OCSP count with two.
+func requiredSCTs(lifetime time.Duration) int {
+	if lifetime <= 180*24*time.Hour {
+		return 2
+	}
+	return 3
+}
+
+// reportSCTs lists the SCTs for leaf from its extension, the TLS handshake and stapled OCSP, and whether they
+// satisfy the browsers' Certificate Transparency policy. Log signatures are not verified, as that needs the logs'
+// keys.
+func reportSCTs(leaf *x509.Certificate, fromTLS, fromOCSP [][]byte) {
+	var all []sct
+	add := func(raws [][]byte, source string) {
+		for _, raw := range raws {
+			if sc, err := parseSCT(raw, source); err == nil {
+				all = append(all, sc)
+			} else {
+				fmt.Printf("  unreadable SCT from %s: %v\n", source, err)
+			}
+		}
+	}
+	fmt.Printf("Certificate Transparency:\n")
+	for _, ext := range leaf.Extensions {
+		if ext.Id.Equal(oidSCTList) {
+			raws, err := parseSCTListExtension(ext.Value)
+			if err != nil {
+				fmt.Printf("  unreadable embedded SCT list: %v\n", err)
+			}
+			add(raws, "certificate")
+		}
+	}
+	add(fromTLS, "TLS extension")
+	add(fromOCSP, "stapled OCSP")
+
+	embedded := map[string]bool{}
+	delivered := map[string]bool{}
+	for _, sc := range all {
+		logID := base64.StdEncoding.EncodeToString(sc.logID)
+		fmt.Printf("  SCT v%d from %s: log %s, %s\n", sc.version+1, sc.source, logID, sc.timestamp.UTC().Format(time.RFC3339))
+		if sc.source == "certificate" {
+			embedded[logID] = true
+		} else {
+			delivered[logID] = true
+		}
+	}
+
+	lifetime := leaf.NotAfter.Sub(leaf.NotBefore)
+	need := requiredSCTs(lifetime)
+	switch {
+	case len(embedded) >= need:
+		fmt.Printf("CT policy: met, %d embedded SCTs from distinct logs, %d needed for a %d day certificate\n", len(embedded), need, int(lifetime.Hours()/24))
+	case len(delivered) >= 2:
+		fmt.Printf("CT policy: met, %d SCTs from distinct logs delivered by TLS or OCSP\n", len(delivered))
+	case len(all) == 0:
+		fmt.Printf("CT policy: not met, no SCTs (fine for a private CA, rejected by browsers for public ones)\n")
+	default:
+		fmt.Printf("CT policy: not met, %d embedded and %d delivered SCTs from distinct logs, %d embedded needed for a %d day certificate\n",
+			len(embedded), len(delivered), need, int(lifetime.Hours()/24))
+	}
+}
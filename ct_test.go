@@ -0,0 +1,107 @@
+package main
+
+import (
+	"bytes"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/binary"
+	"strings"
+	"testing"
+	"time"
+)
+
+// testSCT builds a raw v1 SCT from the log whose ID is filled with logByte.
+func testSCT(logByte byte, at time.Time) []byte {
+	raw := append([]byte{0}, bytes.Repeat([]byte{logByte}, 32)...)
+	raw = binary.BigEndian.AppendUint64(raw, uint64(at.UnixMilli()))
+	return append(raw, 0, 0) // no extensions; the signature is not looked at
+}
+
+func sctList(raws ...[]byte) []byte {
+	var list []byte
+	for _, raw := range raws {
+		list = binary.BigEndian.AppendUint16(list, uint16(len(raw)))
+		list = append(list, raw...)
+	}
+	return append(binary.BigEndian.AppendUint16(nil, uint16(len(list))), list...)
+}
+
+func TestParseSCTList(t *testing.T) {
+	a, b := []byte("first"), []byte("second sct")
+	tests := []struct {
+		name    string
+		data    []byte
+		want    [][]byte
+		wantErr bool
+	}{
+		{name: "two", data: sctList(a, b), want: [][]byte{a, b}},
+		{name: "empty list", data: sctList()},
+		{name: "too short", data: []byte{0}, wantErr: true},
+		{name: "list length", data: append(sctList(a), 0), wantErr: true},
+		{name: "truncated SCT", data: []byte{0, 3, 0, 5, 'x'}, wantErr: true},
+		{name: "truncated SCT length", data: []byte{0, 1, 0}, wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := parseSCTList(tt.data)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("%s: error = %v, want error %v", tt.name, err, tt.wantErr)
+			continue
+		}
+		if len(got) != len(tt.want) {
+			t.Errorf("%s: got %d SCTs, want %d", tt.name, len(got), len(tt.want))
+			continue
+		}
+		for i := range got {
+			if !bytes.Equal(got[i], tt.want[i]) {
+				t.Errorf("%s: SCT %d = %q, want %q", tt.name, i, got[i], tt.want[i])
+			}
+		}
+	}
+}
+
+func TestParseSCT(t *testing.T) {
+	at := time.UnixMilli(1700000000123)
+	sc, err := parseSCT(testSCT(7, at), "certificate")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sc.version != 0 || !bytes.Equal(sc.logID, bytes.Repeat([]byte{7}, 32)) || !sc.timestamp.Equal(at) || sc.source != "certificate" {
+		t.Errorf("parseSCT() = %+v", sc)
+	}
+	if _, err := parseSCT(make([]byte, 40), "certificate"); err == nil {
+		t.Errorf("parseSCT() of a truncated SCT succeeded")
+	}
+}
+
+func TestReportSCTs(t *testing.T) {
+	now := time.Now()
+	leafWith := func(lifetime time.Duration, raws ...[]byte) *x509.Certificate {
+		leaf := &x509.Certificate{NotBefore: now, NotAfter: now.Add(lifetime)}
+		if raws != nil {
+			value, _ := asn1.Marshal(sctList(raws...))
+			leaf.Extensions = []pkix.Extension{{Id: oidSCTList, Value: value}}
+		}
+		return leaf
+	}
+	const year = 365 * 24 * time.Hour
+	tests := []struct {
+		name      string
+		leaf      *x509.Certificate
+		tls, ocsp [][]byte
+		want      string
+	}{
+		{name: "embedded", leaf: leafWith(90*24*time.Hour, testSCT(1, now), testSCT(2, now)), want: "CT policy: met, 2 embedded"},
+		{name: "long lived needs three", leaf: leafWith(year, testSCT(1, now), testSCT(2, now)), want: "CT policy: not met, 2 embedded and 0 delivered SCTs from distinct logs, 3 embedded needed"},
+		{name: "same log twice", leaf: leafWith(90*24*time.Hour, testSCT(1, now), testSCT(1, now)), want: "CT policy: not met, 1 embedded"},
+		{name: "delivered", leaf: leafWith(year), tls: [][]byte{testSCT(1, now)}, ocsp: [][]byte{testSCT(2, now)}, want: "CT policy: met, 2 SCTs from distinct logs delivered"},
+		{name: "none", leaf: leafWith(year), want: "CT policy: not met, no SCTs"},
+		{name: "unreadable", leaf: leafWith(year), tls: [][]byte{{0}}, want: "unreadable SCT from TLS extension"},
+	}
+	for _, tt := range tests {
+		out := captureStdout(t, func() { reportSCTs(tt.leaf, tt.tls, tt.ocsp) })
+		if !strings.Contains(out, tt.want) {
+			t.Errorf("%s: report lacks %q:\n%s", tt.name, tt.want, out)
+		}
+	}
+}
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"net"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// dialTimeout is the default of -connect-timeout.
+const dialTimeout = 500 * time.Millisecond
+
+// dialOptions are the socket settings a session applies to every connection it dials. The ones set through
+// setsockopt before connecting (see controlSocket) are Linux only.
+type dialOptions struct {
+	timeout          time.Duration
+	sourceIP         net.IP
+	portMin, portMax int // local port range, zero for an ephemeral port
+	device           string
+	mark             int
+
+	keepAlive         time.Duration // idle time before the first probe; zero for Go's default, negative disables
+	keepAliveInterval time.Duration // zero for Go's default
+	keepAliveCount    int           // zero for Go's default
+	noDelay           bool
+	fastOpen          bool
+	congestion        string
+	sndBuf, rcvBuf    int
+}
+
+// needsControl reports whether any option has to be applied with setsockopt before connecting.
+func (d dialOptions) needsControl() bool {
+	return d.device != "" || d.mark != 0 || d.fastOpen || d.congestion != "" || d.sndBuf != 0 || d.rcvBuf != 0
+}
+
+// parseDialOptions validates the flags that shape the sockets a session dials.
+func parseDialOptions(opts options) (dialOptions, error) {
+	var d dialOptions
+	if opts.sourceIP != "" {
+		if d.sourceIP = net.ParseIP(opts.sourceIP); d.sourceIP == nil {
+			return d, fmt.Errorf("bad source ip %q", opts.sourceIP)
+		}
+	}
+	if opts.sourcePort != "" {
+		lo, hi, isRange := strings.Cut(opts.sourcePort, "-")
+		if !isRange {
+			hi = lo
+		}
+		var err1, err2 error
+		d.portMin, err1 = strconv.Atoi(lo)
+		d.portMax, err2 = strconv.Atoi(hi)
+		if err1 != nil || err2 != nil || d.portMin < 1 || d.portMax > 65535 || d.portMin > d.portMax {
+			return d, fmt.Errorf("bad source port range %q", opts.sourcePort)
+		}
+	}
+	d.timeout = opts.connectTimeout
+	d.device = opts.device
+	d.mark = opts.fwmark
+	d.keepAlive = opts.keepAlive
+	d.keepAliveInterval = opts.keepAliveInterval
+	d.keepAliveCount = opts.keepAliveCount
+	d.noDelay = opts.noDelay
+	d.fastOpen = opts.fastOpen
+	d.congestion = opts.congestion
+	d.sndBuf = opts.sndBuf
+	d.rcvBuf = opts.rcvBuf
+	if d.timeout < 0 {
+		return d, errors.New("connect timeout must not be negative")
+	}
+	if d.keepAliveInterval < 0 || d.keepAliveCount < 0 || d.sndBuf < 0 || d.rcvBuf < 0 {
+		return d, errors.New("keepalive interval and count and socket buffer sizes must not be negative")
+	}
+	if d.keepAlive < 0 && (d.keepAliveInterval != 0 || d.keepAliveCount != 0) {
+		return d, errors.New("keepalive interval and count need keepalive enabled")
+	}
+	if d.needsControl() && !socketOptionsSupported {
+		return d, errors.New("-interface, -fwmark, -tcp-fastopen, -congestion, -sndbuf and -rcvbuf are only supported on Linux")
+	}
+	return d, nil
+}
+
+// dialContext dials addr with the configured source address and records the connection for -tcp-info.
+func (cc *connConfig) dialContext(ctx context.Context, network, addr string) (net.Conn, error) {
+	var d dialOptions
+	if cc != nil {
+		d = cc.dial
+	}
+	conn, err := d.dialContext(ctx, network, addr)
+	if err == nil && cc != nil && cc.tcpConns != nil {
+		conn = cc.tcpConns.add(conn)
+	}
+	return conn, err
+}
+
+// dialContext tries each port of the source port range, starting at a random one, until one is free.
+func (d dialOptions) dialContext(ctx context.Context, network, addr string) (net.Conn, error) {
+	if d.portMin == 0 {
+		return d.dial(ctx, 0, network, addr)
+	}
+	span := d.portMax - d.portMin + 1
+	first := rand.Intn(span)
+	var err error
+	for i := 0; i < span; i++ {
+		port := d.portMin + (first+i)%span
+		var conn net.Conn
+		conn, err = d.dial(ctx, port, network, addr)
+		if !errors.Is(err, syscall.EADDRINUSE) {
+			return conn, err
+		}
+	}
+	return nil, fmt.Errorf("no free source port in %d-%d: %w", d.portMin, d.portMax, err)
+}
+
+func (d dialOptions) dial(ctx context.Context, port int, network, addr string) (net.Conn, error) {
+	dialer := &net.Dialer{Timeout: d.timeout, KeepAlive: d.keepAlive}
+	if d.keepAlive >= 0 {
+		// Go applies the keepalive config after connecting, so it would undo any of these set in Control.
+		dialer.KeepAliveConfig = net.KeepAliveConfig{
+			Enable:   true,
+			Idle:     d.keepAlive,
+			Interval: d.keepAliveInterval,
+			Count:    d.keepAliveCount,
+		}
+	}
+	if d.sourceIP != nil || port != 0 {
+		dialer.LocalAddr = &net.TCPAddr{IP: d.sourceIP, Port: port}
+	}
+	if d.needsControl() {
+		dialer.Control = func(network, address string, c syscall.RawConn) error {
+			var sockErr error
+			if err := c.Control(func(fd uintptr) { sockErr = controlSocket(fd, d) }); err != nil {
+				return err
+			}
+			return sockErr
+		}
+	}
+	conn, err := dialer.DialContext(ctx, network, addr)
+	if err != nil {
+		return nil, err
+	}
+	// Go enables TCP_NODELAY on every TCP connection, so only turning it off needs doing.
+	if tc, ok := conn.(*net.TCPConn); ok && !d.noDelay {
+		if err := tc.SetNoDelay(false); err != nil {
+			conn.Close()
+			return nil, err
+		}
+	}
+	return conn, nil
+}
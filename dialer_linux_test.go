@@ -0,0 +1,41 @@
+package main
+
+import (
+	"context"
+	"net"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestDialKeepAlive(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+
+	d := dialOptions{timeout: time.Second, keepAlive: 30 * time.Second, keepAliveInterval: 1500 * time.Millisecond, keepAliveCount: 4}
+	conn, err := d.dialContext(context.Background(), "tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	raw, err := conn.(*net.TCPConn).SyscallConn()
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := map[string]int{}
+	raw.Control(func(fd uintptr) {
+		for name, opt := range map[string]int{"idle": syscall.TCP_KEEPIDLE, "interval": syscall.TCP_KEEPINTVL, "count": syscall.TCP_KEEPCNT} {
+			got[name], _ = syscall.GetsockoptInt(int(fd), syscall.IPPROTO_TCP, opt)
+		}
+	})
+	// The kernel counts in whole seconds; Go rounds up.
+	want := map[string]int{"idle": 30, "interval": 2, "count": 4}
+	for name, v := range want {
+		if got[name] != v {
+			t.Errorf("keepalive %s = %d, want %d", name, got[name], v)
+		}
+	}
+}
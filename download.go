@@ -0,0 +1,279 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// byteRange is a single range from a Range header. An open-ended range has end -1, and a suffix range ("last n
+// bytes") has start -1 and end n.
+type byteRange struct {
+	start, end int64
+}
+
+func parseByteRange(s string) (byteRange, error) {
+	spec := strings.TrimPrefix(strings.TrimSpace(s), "bytes=")
+	if strings.Contains(spec, ",") {
+		return byteRange{}, fmt.Errorf("multiple ranges are not supported: %q", s)
+	}
+	first, last, ok := strings.Cut(spec, "-")
+	if !ok {
+		return byteRange{}, fmt.Errorf("bad range %q", s)
+	}
+	r := byteRange{start: -1, end: -1}
+	var err error
+	if first != "" {
+		if r.start, err = strconv.ParseInt(first, 10, 64); err != nil || r.start < 0 {
+			return byteRange{}, fmt.Errorf("bad range start in %q", s)
+		}
+	}
+	if last != "" {
+		if r.end, err = strconv.ParseInt(last, 10, 64); err != nil || r.end < 0 {
+			return byteRange{}, fmt.Errorf("bad range end in %q", s)
+		}
+	}
+	if first == "" && last == "" || r.start >= 0 && r.end >= 0 && r.end < r.start {
+		return byteRange{}, fmt.Errorf("bad range %q", s)
+	}
+	return r, nil
+}
+
+func (r byteRange) header() string {
+	var b strings.Builder
+	b.WriteString("bytes=")
+	if r.start >= 0 {
+		b.WriteString(strconv.FormatInt(r.start, 10))
+	}
+	b.WriteString("-")
+	if r.end >= 0 {
+		b.WriteString(strconv.FormatInt(r.end, 10))
+	}
+	return b.String()
+}
+
+// contentRange is a parsed "Content-Range: bytes first-last/total" header. Total is -1 when the server sent "*".
+type contentRange struct {
+	first, last, total int64
+}
+
+func parseContentRange(s string) (contentRange, error) {
+	spec, ok := strings.CutPrefix(s, "bytes ")
+	if !ok {
+		return contentRange{}, fmt.Errorf("Content-Range %q does not use the bytes unit", s)
+	}
+	span, total, ok := strings.Cut(spec, "/")
+	if !ok {
+		return contentRange{}, fmt.Errorf("Content-Range %q has no total length", s)
+	}
+	cr := contentRange{total: -1}
+	if total != "*" {
+		n, err := strconv.ParseInt(total, 10, 64)
+		if err != nil || n < 0 {
+			return contentRange{}, fmt.Errorf("Content-Range %q has a bad total length", s)
+		}
+		cr.total = n
+	}
+	first, last, ok := strings.Cut(span, "-")
+	if !ok {
+		return contentRange{}, fmt.Errorf("Content-Range %q has no byte span", s)
+	}
+	var err1, err2 error
+	cr.first, err1 = strconv.ParseInt(first, 10, 64)
+	cr.last, err2 = strconv.ParseInt(last, 10, 64)
+	if err1 != nil || err2 != nil || cr.first < 0 || cr.last < cr.first || cr.total >= 0 && cr.last >= cr.total {
+		return contentRange{}, fmt.Errorf("Content-Range %q has a bad byte span", s)
+	}
+	return cr, nil
+}
+
+// checkRangeResponse verifies that resp is a correct 206 answer to a request for r, and that bodyLen bytes of body
+// match what its Content-Range announced. Pass bodyLen -1 to skip the length check.
+func checkRangeResponse(resp *http.Response, r byteRange, bodyLen int64) (contentRange, error) {
+	if resp.StatusCode != http.StatusPartialContent {
+		return contentRange{}, newError(errCheckFailed, "expected 206 Partial Content for %s, got %s", r.header(), resp.Status)
+	}
+	header := resp.Header.Get("Content-Range")
+	if header == "" {
+		return contentRange{}, newError(errCheckFailed, "206 response without Content-Range")
+	}
+	cr, err := parseContentRange(header)
+	if err != nil {
+		return contentRange{}, &toolError{kind: errCheckFailed, err: err}
+	}
+
+	// Negative wanted positions are ones that cannot be known without the total length.
+	wantFirst, wantLast := r.start, r.end
+	switch {
+	case r.start < 0:
+		wantFirst, wantLast = -1, -1
+		if cr.total >= 0 {
+			wantFirst, wantLast = max(cr.total-r.end, 0), cr.total-1
+		}
+	case r.end < 0 || cr.total >= 0 && r.end >= cr.total:
+		wantLast = cr.total - 1
+	}
+	if wantFirst >= 0 && cr.first != wantFirst {
+		return cr, newError(errCheckFailed, "Content-Range %q starts at %d, requested %d", header, cr.first, wantFirst)
+	}
+	if wantLast >= 0 && cr.last != wantLast {
+		return cr, newError(errCheckFailed, "Content-Range %q ends at %d, expected %d", header, cr.last, wantLast)
+	}
+	if span := cr.last - cr.first + 1; bodyLen >= 0 && bodyLen != span {
+		return cr, newError(errCheckFailed, "Content-Range %q announces %d bytes, body has %d", header, span, bodyLen)
+	}
+	return cr, nil
+}
+
+// resumeValidator picks the validator to send in If-Range. RFC 9110 only allows a strong ETag there, so a weak one
+// falls back to Last-Modified.
+func resumeValidator(resp *http.Response) string {
+	if etag := resp.Header.Get("ETag"); etag != "" && !strings.HasPrefix(etag, "W/") {
+		return etag
+	}
+	return resp.Header.Get("Last-Modified")
+}
+
+// download writes the response to a method request for urlStr, which sends body if it is not nil, to path. With
+// resume, an existing partial file is continued with a Range request guarded by If-Range, using the validator saved
+// next to it, and a GET transfer that breaks off mid-body is resumed the same way up to opts.retries times. A server
+// that answers a resumed request with the whole object, because it changed or does not do ranges, restarts the
+// download from scratch.
+func (s *session) download(urlStr string, overrides dialOverrides, method string, body []byte, path string, resume bool, r *byteRange) error {
+	flags := os.O_WRONLY | os.O_CREATE
+	if !resume {
+		flags |= os.O_TRUNC
+	}
+	f, err := os.OpenFile(path, flags, 0o644)
+	if err != nil {
+		return newError(errBadInput, "opening output failed: %v", err)
+	}
+	defer f.Close()
+
+	validatorPath := path + ".validator"
+	var offset int64
+	var validator string
+	if resume {
+		if offset, err = f.Seek(0, io.SeekEnd); err != nil {
+			return err
+		}
+		if data, err := os.ReadFile(validatorPath); err == nil {
+			validator = strings.TrimSpace(string(data))
+		}
+	}
+
+	total := int64(-1)
+	for attempt := 0; ; attempt++ {
+		req, err := s.newRequest(context.Background(), method, urlStr, body)
+		if err != nil {
+			return err
+		}
+		want := r
+		if offset > 0 {
+			want = &byteRange{start: offset, end: -1}
+			if validator != "" {
+				req.Header.Set("If-Range", validator)
+			}
+			fmt.Printf("Resuming at byte %d\n", offset)
+		}
+		if want != nil {
+			req.Header.Set("Range", want.header())
+		}
+
+		resp, err := s.do(req, overrides)
+		if err != nil {
+			return err
+		}
+		span := int64(-1) // bytes announced by Content-Range
+		if resp.StatusCode == http.StatusRequestedRangeNotSatisfiable && offset > 0 {
+			cr := resp.Header.Get("Content-Range")
+			resp.Body.Close()
+			if cr != "bytes */"+strconv.FormatInt(offset, 10) {
+				return newError(errCheckFailed, "resuming at byte %d: %s with Content-Range %q", offset, resp.Status, cr)
+			}
+			fmt.Printf("Already complete\n")
+			total = offset
+			break
+		}
+		switch {
+		case resp.StatusCode == http.StatusOK && offset > 0:
+			fmt.Printf("Server sent the whole object (changed, or ranges unsupported); restarting\n")
+			if err := f.Truncate(0); err != nil {
+				resp.Body.Close()
+				return err
+			}
+			offset = 0
+		case resp.StatusCode == http.StatusOK && want == nil:
+		case resp.StatusCode == http.StatusPartialContent && want != nil:
+			cr, err := checkRangeResponse(resp, *want, -1)
+			if err != nil {
+				resp.Body.Close()
+				return err
+			}
+			if total >= 0 && cr.total >= 0 && cr.total != total {
+				resp.Body.Close()
+				return newError(errCheckFailed, "object length changed from %d to %d between requests", total, cr.total)
+			}
+			if offset > 0 && validator != "" && resumeValidator(resp) != "" && resumeValidator(resp) != validator {
+				resp.Body.Close()
+				return newError(errCheckFailed, "resumed response has validator %q, expected %q", resumeValidator(resp), validator)
+			}
+			fmt.Printf("Content-Range: %s\n", resp.Header.Get("Content-Range"))
+			total = cr.total
+			span = cr.last - cr.first + 1
+		default:
+			resp.Body.Close()
+			return newError(errCheckFailed, "unexpected %s for %s", resp.Status, req.Header.Get("Range"))
+		}
+		if total < 0 && resp.StatusCode == http.StatusOK {
+			total = resp.ContentLength
+		}
+		if validator = resumeValidator(resp); validator != "" && r == nil {
+			if err := os.WriteFile(validatorPath, []byte(validator+"\n"), 0o644); err != nil {
+				resp.Body.Close()
+				return err
+			}
+		}
+
+		if _, err := f.Seek(offset, io.SeekStart); err != nil {
+			resp.Body.Close()
+			return err
+		}
+		n, copyErr := io.Copy(f, resp.Body)
+		resp.Body.Close()
+		offset += n
+		if copyErr != nil {
+			var we *os.PathError
+			if errors.As(copyErr, &we) {
+				return copyErr
+			}
+			// Ranges are only defined for GET, so anything else cannot pick up where it broke off.
+			if r != nil || method != http.MethodGet || attempt >= s.opts.retries {
+				return &toolError{kind: errRead, err: fmt.Errorf("download interrupted at byte %d: %w", offset, copyErr)}
+			}
+			fmt.Printf("Download interrupted at byte %d: %v\n", offset, copyErr)
+			continue
+		}
+		// A body without a Content-Length that ends early is not an error to io.Copy.
+		if r != nil && span >= 0 && n != span {
+			return newError(errCheckFailed, "incomplete transfer: wrote %d bytes, Content-Range announced %d", n, span)
+		}
+		break
+	}
+
+	size, err := f.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return err
+	}
+	if r == nil && total >= 0 && size != total {
+		return newError(errCheckFailed, "downloaded %d bytes, object has %d", size, total)
+	}
+	os.Remove(validatorPath)
+	fmt.Printf("Saved %d bytes to %s\n", size, path)
+	return nil
+}
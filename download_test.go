@@ -0,0 +1,224 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseByteRange(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    byteRange
+		header  string
+		wantErr bool
+	}{
+		{in: "0-99", want: byteRange{0, 99}, header: "bytes=0-99"},
+		{in: "bytes=100-", want: byteRange{100, -1}, header: "bytes=100-"},
+		{in: "-500", want: byteRange{-1, 500}, header: "bytes=-500"},
+		{in: " 5-5 ", want: byteRange{5, 5}, header: "bytes=5-5"},
+		{in: "0-1,5-6", wantErr: true},
+		{in: "10", wantErr: true},
+		{in: "-", wantErr: true},
+		{in: "9-3", wantErr: true},
+		{in: "a-3", wantErr: true},
+		{in: "3-b", wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := parseByteRange(tt.in)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("parseByteRange(%q) error = %v, want error %v", tt.in, err, tt.wantErr)
+			continue
+		}
+		if tt.wantErr {
+			continue
+		}
+		if got != tt.want || got.header() != tt.header {
+			t.Errorf("parseByteRange(%q) = %+v (%s), want %+v (%s)", tt.in, got, got.header(), tt.want, tt.header)
+		}
+	}
+}
+
+func TestParseContentRange(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    contentRange
+		wantErr bool
+	}{
+		{in: "bytes 0-99/1000", want: contentRange{0, 99, 1000}},
+		{in: "bytes 900-999/*", want: contentRange{900, 999, -1}},
+		{in: "bytes 0-0/1", want: contentRange{0, 0, 1}},
+		{in: "items 0-9/10", wantErr: true},
+		{in: "bytes 0-9", wantErr: true},
+		{in: "bytes */100", wantErr: true},
+		{in: "bytes 5-4/100", wantErr: true},
+		{in: "bytes 0-100/100", wantErr: true},
+		{in: "bytes 0-9/-1", wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := parseContentRange(tt.in)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("parseContentRange(%q) error = %v, want error %v", tt.in, err, tt.wantErr)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("parseContentRange(%q) = %+v, want %+v", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestCheckRangeResponse(t *testing.T) {
+	tests := []struct {
+		name         string
+		r            byteRange
+		contentRange string
+		bodyLen      int64
+		wantErr      bool
+	}{
+		{name: "exact", r: byteRange{0, 9}, contentRange: "bytes 0-9/100", bodyLen: 10},
+		{name: "open ended", r: byteRange{90, -1}, contentRange: "bytes 90-99/100", bodyLen: -1},
+		{name: "suffix", r: byteRange{-1, 10}, contentRange: "bytes 90-99/100", bodyLen: 10},
+		{name: "end past total", r: byteRange{90, 200}, contentRange: "bytes 90-99/100", bodyLen: 10},
+		{name: "unknown total", r: byteRange{-1, 10}, contentRange: "bytes 90-99/*", bodyLen: 10},
+		{name: "wrong start", r: byteRange{0, 9}, contentRange: "bytes 1-9/100", bodyLen: -1, wantErr: true},
+		{name: "wrong end", r: byteRange{0, 9}, contentRange: "bytes 0-8/100", bodyLen: -1, wantErr: true},
+		{name: "short body", r: byteRange{0, 9}, contentRange: "bytes 0-9/100", bodyLen: 5, wantErr: true},
+		{name: "no Content-Range", r: byteRange{0, 9}, bodyLen: -1, wantErr: true},
+	}
+	for _, tt := range tests {
+		resp := &http.Response{StatusCode: http.StatusPartialContent, Header: http.Header{}}
+		if tt.contentRange != "" {
+			resp.Header.Set("Content-Range", tt.contentRange)
+		}
+		if _, err := checkRangeResponse(resp, tt.r, tt.bodyLen); (err != nil) != tt.wantErr {
+			t.Errorf("%s: error = %v, want error %v", tt.name, err, tt.wantErr)
+		}
+	}
+}
+
+func TestDownloadRangeIncomplete(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Range", "bytes 0-9/100")
+		w.WriteHeader(http.StatusPartialContent)
+		// Without a Content-Length the body is chunked, so ending it early looks like a clean end to the client.
+		io.WriteString(w, "short")
+		w.(http.Flusher).Flush()
+	}))
+	defer srv.Close()
+
+	s, err := newSession(options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.close()
+	path := filepath.Join(t.TempDir(), "part")
+	err = s.download(srv.URL, nil, http.MethodGet, nil, path, false, &byteRange{0, 9})
+	if kindOf(err) != errCheckFailed {
+		t.Fatalf("download() = %v, want a failed check", err)
+	}
+	if data, _ := os.ReadFile(path); string(data) != "short" {
+		t.Errorf("output has %q", data)
+	}
+}
+
+func TestDownloadResume(t *testing.T) {
+	const content = "0123456789abcdefghijklmnopqrstuvwxyz"
+	tests := []struct {
+		name          string
+		partial       string // already in the output file
+		validator     string // saved next to it
+		interrupt     bool   // break off the first response halfway
+		retries       int
+		wantIfRange   []string // If-Range of each request
+		wantErr       bool
+		wantSays      string
+		wantValidator bool // the validator file is left behind
+	}{
+		{name: "fresh", wantIfRange: []string{""}},
+		{name: "resume", partial: content[:10], validator: `"v1"`, wantIfRange: []string{`"v1"`}, wantSays: "Resuming at byte 10"},
+		{name: "changed", partial: "stale data", validator: `"v0"`, wantIfRange: []string{`"v0"`}, wantSays: "restarting"},
+		{name: "already complete", partial: content, validator: `"v1"`, wantIfRange: []string{`"v1"`}, wantSays: "Already complete"},
+		{name: "interrupted", interrupt: true, retries: 1, wantIfRange: []string{"", `"v1"`}, wantSays: "Download interrupted at byte 18"},
+		{name: "interrupted without retries", interrupt: true, wantIfRange: []string{""}, wantErr: true, wantValidator: true},
+	}
+	for _, tt := range tests {
+		var ifRange []string
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			ifRange = append(ifRange, r.Header.Get("If-Range"))
+			w.Header().Set("ETag", `"v1"`)
+			if tt.interrupt && len(ifRange) == 1 {
+				w.Header().Set("Content-Length", strconv.Itoa(len(content)))
+				io.WriteString(w, content[:len(content)/2])
+				w.(http.Flusher).Flush()
+				panic(http.ErrAbortHandler)
+			}
+			http.ServeContent(w, r, "", time.Time{}, strings.NewReader(content))
+		}))
+
+		s, err := newSession(options{retries: tt.retries})
+		if err != nil {
+			t.Fatal(err)
+		}
+		path := filepath.Join(t.TempDir(), "out")
+		resume := tt.partial != "" || tt.interrupt
+		if tt.partial != "" {
+			os.WriteFile(path, []byte(tt.partial), 0o644)
+			os.WriteFile(path+".validator", []byte(tt.validator+"\n"), 0o644)
+		}
+		out := captureStdout(t, func() { err = s.download(srv.URL, nil, http.MethodGet, nil, path, resume, nil) })
+		s.close()
+		srv.Close()
+
+		if (err != nil) != tt.wantErr {
+			t.Errorf("%s: download() = %v, want error %v", tt.name, err, tt.wantErr)
+		}
+		if !slices.Equal(ifRange, tt.wantIfRange) {
+			t.Errorf("%s: If-Range headers = %q, want %q", tt.name, ifRange, tt.wantIfRange)
+		}
+		if !strings.Contains(out, tt.wantSays) {
+			t.Errorf("%s: output lacks %q:\n%s", tt.name, tt.wantSays, out)
+		}
+		if data, _ := os.ReadFile(path); !tt.wantErr && string(data) != content {
+			t.Errorf("%s: output has %q", tt.name, data)
+		}
+		if _, err := os.Stat(path + ".validator"); (err == nil) != tt.wantValidator {
+			t.Errorf("%s: validator file left behind = %v, want %v", tt.name, err == nil, tt.wantValidator)
+		}
+	}
+}
+
+func TestDownloadPost(t *testing.T) {
+	var requests int
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		body, _ := io.ReadAll(r.Body)
+		if r.Header.Get("Range") != "" {
+			t.Errorf("POST sent Range %q", r.Header.Get("Range"))
+		}
+		w.Header().Set("Content-Length", "100")
+		w.Write(append([]byte(r.Method+" "), body...))
+	}))
+	defer srv.Close()
+
+	s, err := newSession(options{retries: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.close()
+	path := filepath.Join(t.TempDir(), "out")
+	// The body is shorter than its Content-Length, and a POST cannot be resumed with a range.
+	captureStdout(t, func() { err = s.download(srv.URL, nil, http.MethodPost, []byte("query"), path, false, nil) })
+	if kindOf(err) != errRead || requests != 1 {
+		t.Errorf("download() = %v after %d requests, want a read error after 1", err, requests)
+	}
+	if data, _ := os.ReadFile(path); string(data) != "POST query" {
+		t.Errorf("output has %q", data)
+	}
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/url"
+	"os"
+	"syscall"
+)
+
+// errorKind is the machine-readable class of a failure. Each kind has its own exit status so scripts can tell a
+// backend that is down from one with a broken certificate without parsing messages.
+type errorKind string
+
+const (
+	errOther          errorKind = "other"
+	errBadInput       errorKind = "bad_input"
+	errDNS            errorKind = "dns_failure"
+	errRefused        errorKind = "connection_refused"
+	errDialTimeout    errorKind = "dial_timeout"
+	errTLSHostname    errorKind = "tls_hostname_mismatch"
+	errTLSUnknownCA   errorKind = "tls_unknown_ca"
+	errTLSExpired     errorKind = "tls_expired"
+	errTLS            errorKind = "tls_error"
+	errProtocol       errorKind = "protocol_error"
+	errTimeout        errorKind = "timeout"
+	errRead           errorKind = "read_error"
+	errCheckFailed    errorKind = "check_failed"
+	errTLSRevoked     errorKind = "tls_revoked"
+	errTLSPinMismatch errorKind = "tls_pin_mismatch"
+)
+
+// errorKinds lists every kind in exit status order. The exit status of a kind is its position plus one, so
+// append new kinds at the end to keep documented statuses stable.
+var errorKinds = []errorKind{
+	errOther,
+	errBadInput,
+	errDNS,
+	errRefused,
+	errDialTimeout,
+	errTLSHostname,
+	errTLSUnknownCA,
+	errTLSExpired,
+	errTLS,
+	errProtocol,
+	errTimeout,
+	errRead,
+	errCheckFailed,
+	errTLSRevoked,
+	errTLSPinMismatch,
+}
+
+func (k errorKind) exitCode() int {
+	for i, kind := range errorKinds {
+		if kind == k {
+			return i + 1
+		}
+	}
+	return 1
+}
+
+// toolError is a failure that has been classified. Errors that reach exitWithError without one are classified
+// from their chain.
+type toolError struct {
+	kind errorKind
+	err  error
+}
+
+func (e *toolError) Error() string { return e.err.Error() }
+func (e *toolError) Unwrap() error { return e.err }
+
+func newError(kind errorKind, format string, args ...any) error {
+	return &toolError{kind: kind, err: fmt.Errorf(format, args...)}
+}
+
+// wrapError adds context to err's message while keeping its kind.
+func wrapError(err error, format string, args ...any) error {
+	return &toolError{kind: kindOf(err), err: fmt.Errorf(format+": %w", append(args, err)...)}
+}
+
+func kindOf(err error) errorKind {
+	var te *toolError
+	if errors.As(err, &te) {
+		return te.kind
+	}
+	return classifyError(err)
+}
+
+// classifyError inspects the chain of an error returned by the HTTP client. Failures the tool raised itself, such as
+// a pin mismatch from a dial hook, keep their kind.
+func classifyError(err error) errorKind {
+	var (
+		te         *toolError
+		dnsErr     *net.DNSError
+		opErr      *net.OpError
+		netErr     net.Error
+		hostErr    x509.HostnameError
+		caErr      x509.UnknownAuthorityError
+		invalidErr x509.CertificateInvalidError
+		certErr    *tls.CertificateVerificationError
+		alertErr   tls.AlertError
+		recordErr  tls.RecordHeaderError
+		urlErr     *url.Error
+	)
+	switch {
+	case errors.As(err, &te):
+		return te.kind
+	case errors.As(err, &dnsErr):
+		return errDNS
+	case errors.Is(err, syscall.ECONNREFUSED):
+		return errRefused
+	case errors.As(err, &opErr) && opErr.Op == "dial" && opErr.Timeout():
+		return errDialTimeout
+	case errors.As(err, &hostErr):
+		return errTLSHostname
+	case errors.As(err, &caErr):
+		return errTLSUnknownCA
+	case errors.As(err, &invalidErr) && invalidErr.Reason == x509.Expired:
+		return errTLSExpired
+	case errors.As(err, &invalidErr), errors.As(err, &certErr), errors.As(err, &alertErr), errors.As(err, &recordErr):
+		return errTLS
+	case errors.As(err, &netErr) && netErr.Timeout():
+		return errTimeout
+	case errors.As(err, &urlErr):
+		// Anything else the client reports, such as a reset connection or a malformed response.
+		return errProtocol
+	}
+	return errOther
+}
+
+// exitWithError reports err for people on stderr and for scripts on stdout, then exits with its kind's status.
+func exitWithError(err error) {
+	kind := kindOf(err)
+	log.Print(err)
+	fmt.Printf("Error: %s\n", kind)
+	os.Exit(kind.exitCode())
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestExitCodes(t *testing.T) {
+	// Exit statuses are documented, so they must not move when kinds are added.
+	want := map[errorKind]int{
+		errOther:          1,
+		errBadInput:       2,
+		errDNS:            3,
+		errRefused:        4,
+		errDialTimeout:    5,
+		errTLSHostname:    6,
+		errTLSUnknownCA:   7,
+		errTLSExpired:     8,
+		errTLS:            9,
+		errProtocol:       10,
+		errTimeout:        11,
+		errRead:           12,
+		errCheckFailed:    13,
+		errTLSRevoked:     14,
+		errTLSPinMismatch: 15,
+	}
+	if len(errorKinds) != len(want) {
+		t.Errorf("%d error kinds, want %d; add new kinds here too", len(errorKinds), len(want))
+	}
+	for kind, code := range want {
+		if got := kind.exitCode(); got != code {
+			t.Errorf("%s.exitCode() = %d, want %d", kind, got, code)
+		}
+	}
+	if got := errorKind("made_up").exitCode(); got != 1 {
+		t.Errorf("exit code of an unknown kind = %d, want 1", got)
+	}
+}
+
+type timeoutError struct{}
+
+func (timeoutError) Error() string   { return "i/o timeout" }
+func (timeoutError) Timeout() bool   { return true }
+func (timeoutError) Temporary() bool { return true }
+
+func TestClassifyError(t *testing.T) {
+	// clientError wraps err the way http.Client reports it.
+	clientError := func(err error) error {
+		return &url.Error{Op: "Get", URL: "https://example.com/", Err: err}
+	}
+	tests := []struct {
+		name string
+		err  error
+		want errorKind
+	}{
+		{name: "dns", err: clientError(&net.OpError{Op: "dial", Net: "tcp", Err: &net.DNSError{Err: "no such host", Name: "missing.example.com", IsNotFound: true}}), want: errDNS},
+		{name: "refused", err: clientError(&net.OpError{Op: "dial", Net: "tcp", Err: os.NewSyscallError("connect", syscall.ECONNREFUSED)}), want: errRefused},
+		{name: "dial timeout", err: clientError(&net.OpError{Op: "dial", Net: "tcp", Err: timeoutError{}}), want: errDialTimeout},
+		{name: "read timeout", err: clientError(&net.OpError{Op: "read", Net: "tcp", Err: timeoutError{}}), want: errTimeout},
+		{name: "expired", err: clientError(&tls.CertificateVerificationError{Err: x509.CertificateInvalidError{Reason: x509.Expired}}), want: errTLSExpired},
+		{name: "other invalid certificate", err: clientError(x509.CertificateInvalidError{Reason: x509.NotAuthorizedToSign}), want: errTLS},
+		{name: "alert", err: clientError(tls.AlertError(40)), want: errTLS},
+		{name: "tool error", err: clientError(newError(errTLSPinMismatch, "pin mismatch")), want: errTLSPinMismatch},
+		{name: "malformed response", err: clientError(errors.New("malformed HTTP response")), want: errProtocol},
+		{name: "unrelated", err: errors.New("boom"), want: errOther},
+	}
+	for _, tt := range tests {
+		if got := classifyError(tt.err); got != tt.want {
+			t.Errorf("%s: classifyError() = %s, want %s", tt.name, got, tt.want)
+		}
+	}
+}
+
+// newCertServer starts an HTTPS server presenting cert.
+func newCertServer(t *testing.T, cert *testCert) *httptest.Server {
+	t.Helper()
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(helloHandler))
+	srv.TLS = &tls.Config{Certificates: []tls.Certificate{{Certificate: [][]byte{cert.cert.Raw}, PrivateKey: cert.key}}}
+	srv.StartTLS()
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func TestClassifyRequestErrors(t *testing.T) {
+	ca := newTestCA(t, "Test CA")
+	otherCA := newTestCA(t, "Other CA")
+	localhost := []net.IP{net.IPv4(127, 0, 0, 1)}
+	untrusted := newCertServer(t, newTestCert(t, &x509.Certificate{SerialNumber: big.NewInt(2), IPAddresses: localhost}, otherCA))
+	expired := newCertServer(t, newTestCert(t, &x509.Certificate{
+		SerialNumber: big.NewInt(3),
+		IPAddresses:  localhost,
+		NotBefore:    time.Now().Add(-48 * time.Hour),
+		NotAfter:     time.Now().Add(-24 * time.Hour),
+	}, ca))
+	// The default httptest certificate is only valid for example.com and loopback addresses.
+	trusted := newTLSTestServer(t, false, http.HandlerFunc(helloHandler))
+	sharedTLSConfig.RootCAs.AddCert(ca.cert)
+
+	closed, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	closedAddr := closed.Addr().String()
+	closed.Close()
+
+	tests := []struct {
+		name      string
+		url       string
+		overrides dialOverrides
+		want      errorKind
+	}{
+		{name: "refused", url: "http://" + closedAddr + "/", want: errRefused},
+		{name: "unknown CA", url: untrusted.URL, want: errTLSUnknownCA},
+		{name: "hostname mismatch", url: "https://mismatch.test/", overrides: dialOverrides{"mismatch.test": trusted.Listener.Addr().String()}, want: errTLSHostname},
+		{name: "expired", url: expired.URL, want: errTLSExpired},
+	}
+	s, err := newSession(options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.close()
+	for _, tt := range tests {
+		req, _ := http.NewRequest(http.MethodGet, tt.url, nil)
+		_, _, err := s.fetch(req, tt.overrides)
+		if got := kindOf(err); got != tt.want {
+			t.Errorf("%s: kind = %s, want %s (%v)", tt.name, got, tt.want, err)
+		}
+	}
+}
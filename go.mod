@@ -1,3 +1,3 @@
 module gotest
 
-go 1.22.4
+go 1.24
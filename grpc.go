@@ -0,0 +1,188 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const grpcHealthCheckPath = "/grpc.health.v1.Health/Check"
+
+// grpcCodes are the names of the gRPC status codes, indexed by code.
+var grpcCodes = []string{
+	"OK", "CANCELLED", "UNKNOWN", "INVALID_ARGUMENT", "DEADLINE_EXCEEDED", "NOT_FOUND", "ALREADY_EXISTS",
+	"PERMISSION_DENIED", "RESOURCE_EXHAUSTED", "FAILED_PRECONDITION", "ABORTED", "OUT_OF_RANGE", "UNIMPLEMENTED",
+	"INTERNAL", "UNAVAILABLE", "DATA_LOSS", "UNAUTHENTICATED",
+}
+
+// healthStatuses are the values of grpc.health.v1.HealthCheckResponse.ServingStatus.
+var healthStatuses = []string{"UNKNOWN", "SERVING", "NOT_SERVING", "SERVICE_UNKNOWN"}
+
+func grpcCodeName(code int) string {
+	if code >= 0 && code < len(grpcCodes) {
+		return grpcCodes[code]
+	}
+	return "code " + strconv.Itoa(code)
+}
+
+// grpcHealthCheck calls grpc.health.v1.Health/Check for service on the server at urlStr, whose scheme picks TLS
+// (https) or cleartext HTTP/2 (http). The messages are small enough to encode by hand, so no stubs are needed.
+func (s *session) grpcHealthCheck(urlStr string, overrides dialOverrides, target, service string, timeout time.Duration) error {
+	u, err := url.Parse(urlStr)
+	if err != nil {
+		return newError(errBadInput, "parsing url failed: %v", err)
+	}
+	u.Path, u.RawQuery = grpcHealthCheckPath, ""
+
+	// HealthCheckRequest { string service = 1; }, behind the 5 byte gRPC message prefix.
+	var msg []byte
+	if service != "" {
+		msg = append([]byte{0x0a}, binary.AppendUvarint(nil, uint64(len(service)))...)
+		msg = append(msg, service...)
+	}
+	frame := append([]byte{0}, binary.BigEndian.AppendUint32(nil, uint32(len(msg)))...)
+	frame = append(frame, msg...)
+
+	req, err := http.NewRequest(http.MethodPost, u.String(), bytes.NewReader(frame))
+	if err != nil {
+		return newError(errBadInput, "building request failed: %v", err)
+	}
+	req.Header.Set("Content-Type", "application/grpc")
+	req.Header.Set("TE", "trailers")
+	req.Header.Set("grpc-timeout", strconv.FormatInt(timeout.Milliseconds(), 10)+"m")
+	ctx, cancel := context.WithTimeout(req.Context(), timeout)
+	defer cancel()
+	if u.Scheme == "http" {
+		ctx = withH2C(ctx)
+	}
+	req = req.WithContext(ctx)
+
+	name := service
+	if name == "" {
+		name = `"" (whole server)`
+	}
+	fmt.Printf("gRPC health check of %s on %s", name, u.Host)
+	if target != "" {
+		fmt.Printf(" via %s", target)
+	}
+	fmt.Printf("\n")
+	start := time.Now()
+	resp, body, err := s.fetch(req, overrides)
+	if err != nil {
+		return err
+	}
+	elapsed := time.Since(start)
+
+	if resp.ProtoMajor != 2 {
+		return newError(errProtocol, "server answered over %s, gRPC needs HTTP/2", resp.Proto)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return newError(errProtocol, "HTTP status %s, not a gRPC server", resp.Status)
+	}
+	if ct := resp.Header.Get("Content-Type"); !strings.HasPrefix(ct, "application/grpc") {
+		return newError(errProtocol, "Content-Type %q is not application/grpc", ct)
+	}
+
+	// A call that fails before any message carries its status in the headers ("trailers-only").
+	status := resp.Trailer.Get("grpc-status")
+	message := resp.Trailer.Get("grpc-message")
+	if status == "" {
+		status = resp.Header.Get("grpc-status")
+		message = resp.Header.Get("grpc-message")
+	}
+	if status == "" {
+		return newError(errProtocol, "response has no grpc-status")
+	}
+	code, err := strconv.Atoi(status)
+	if err != nil {
+		return newError(errProtocol, "bad grpc-status %q", status)
+	}
+	if m, err := url.PathUnescape(message); err == nil {
+		message = m
+	}
+	fmt.Printf("gRPC status: %d %s", code, grpcCodeName(code))
+	if message != "" {
+		fmt.Printf(" (%s)", message)
+	}
+	fmt.Printf(" in %s\n", elapsed.Round(time.Microsecond))
+	if code != 0 {
+		return newError(errCheckFailed, "health check failed with %s: %s", grpcCodeName(code), message)
+	}
+
+	serving, err := parseHealthCheckResponse(body)
+	if err != nil {
+		return &toolError{kind: errProtocol, err: err}
+	}
+	name = "code " + strconv.FormatUint(serving, 10)
+	if serving < uint64(len(healthStatuses)) {
+		name = healthStatuses[serving]
+	}
+	fmt.Printf("Serving status: %s\n", name)
+	if serving != 1 {
+		return newError(errCheckFailed, "service is %s", name)
+	}
+	return nil
+}
+
+// parseHealthCheckResponse returns the status field of the grpc.health.v1.HealthCheckResponse in body, a single
+// length-prefixed gRPC message.
+func parseHealthCheckResponse(body []byte) (uint64, error) {
+	if len(body) < 5 {
+		return 0, fmt.Errorf("response message is %d bytes, shorter than its prefix", len(body))
+	}
+	if body[0] != 0 {
+		return 0, fmt.Errorf("response message is compressed")
+	}
+	n := binary.BigEndian.Uint32(body[1:5])
+	msg := body[5:]
+	if uint32(len(msg)) != n {
+		return 0, fmt.Errorf("response message prefix says %d bytes, got %d", n, len(msg))
+	}
+
+	// ServingStatus status = 1 is the only field; proto3 leaves it out when it is UNKNOWN (0).
+	var status uint64
+	r := bytes.NewReader(msg)
+	for r.Len() > 0 {
+		key, err := binary.ReadUvarint(r)
+		if err != nil {
+			return 0, fmt.Errorf("bad protobuf in response: %w", err)
+		}
+		field, wireType := key>>3, key&7
+		switch wireType {
+		case 0:
+			v, err := binary.ReadUvarint(r)
+			if err != nil {
+				return 0, fmt.Errorf("bad protobuf in response: %w", err)
+			}
+			if field == 1 {
+				status = v
+			}
+		case 1, 5:
+			size := int64(8)
+			if wireType == 5 {
+				size = 4
+			}
+			if _, err := io.CopyN(io.Discard, r, size); err != nil {
+				return 0, fmt.Errorf("bad protobuf in response: %w", err)
+			}
+		case 2:
+			size, err := binary.ReadUvarint(r)
+			if err == nil {
+				_, err = io.CopyN(io.Discard, r, int64(size))
+			}
+			if err != nil {
+				return 0, fmt.Errorf("bad protobuf in response: %w", err)
+			}
+		default:
+			return 0, fmt.Errorf("bad protobuf in response: wire type %d", wireType)
+		}
+	}
+	return status, nil
+}
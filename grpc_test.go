@@ -0,0 +1,132 @@
+package main
+
+import (
+	"encoding/binary"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// grpcMessage wraps msg in the 5 byte gRPC message prefix.
+func grpcMessage(msg []byte) []byte {
+	return append(binary.BigEndian.AppendUint32([]byte{0}, uint32(len(msg))), msg...)
+}
+
+func TestParseHealthCheckResponse(t *testing.T) {
+	tests := []struct {
+		name    string
+		body    []byte
+		want    uint64
+		wantErr bool
+	}{
+		{name: "serving", body: grpcMessage([]byte{0x08, 0x01}), want: 1},
+		{name: "status omitted", body: grpcMessage(nil), want: 0},
+		{
+			name: "unknown fields skipped",
+			body: grpcMessage([]byte{
+				0x10, 0x96, 0x01, // field 2 varint
+				0x19, 1, 2, 3, 4, 5, 6, 7, 8, // field 3 fixed64
+				0x22, 0x02, 'h', 'i', // field 4 bytes
+				0x2d, 1, 2, 3, 4, // field 5 fixed32
+				0x08, 0x02,
+			}),
+			want: 2,
+		},
+		{name: "too short", body: []byte{0, 0, 0}, wantErr: true},
+		{name: "compressed", body: append([]byte{1}, grpcMessage([]byte{0x08, 0x01})[1:]...), wantErr: true},
+		{name: "length mismatch", body: append(grpcMessage([]byte{0x08, 0x01}), 0), wantErr: true},
+		{name: "group wire type", body: grpcMessage([]byte{0x0b}), wantErr: true},
+		{name: "truncated varint", body: grpcMessage([]byte{0x08, 0x80}), wantErr: true},
+		{name: "truncated bytes", body: grpcMessage([]byte{0x12, 0x05, 'a'}), wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := parseHealthCheckResponse(tt.body)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("%s: error = %v, want error %v", tt.name, err, tt.wantErr)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("%s: status = %d, want %d", tt.name, got, tt.want)
+		}
+	}
+}
+
+// grpcHealthHandler answers health checks the way grpc-go does: SERVING for the whole server, NOT_SERVING for
+// "down", and a trailers-only NOT_FOUND for services it does not know.
+func grpcHealthHandler(t *testing.T) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		if r.ProtoMajor != 2 || r.URL.Path != grpcHealthCheckPath || r.Header.Get("Content-Type") != "application/grpc" || r.Header.Get("TE") != "trailers" {
+			t.Errorf("unexpected request %s %s %s %v", r.Proto, r.Method, r.URL.Path, r.Header)
+		}
+		var service string
+		if len(body) > 7 {
+			service = string(body[7:]) // after the prefix, tag and length of a short name
+		}
+		w.Header().Set("Content-Type", "application/grpc")
+		var status byte
+		switch service {
+		case "":
+			status = 1
+		case "down":
+			status = 2
+		default:
+			w.Header().Set("grpc-status", "5")
+			w.Header().Set("grpc-message", "unknown%20service")
+			return
+		}
+		w.Write(grpcMessage([]byte{0x08, status}))
+		w.Header().Set(http.TrailerPrefix+"grpc-status", "0")
+	})
+}
+
+func TestGRPCHealthCheck(t *testing.T) {
+	h2c := httptest.NewUnstartedServer(grpcHealthHandler(t))
+	h2c.Config.Protocols = new(http.Protocols)
+	h2c.Config.Protocols.SetUnencryptedHTTP2(true)
+	h2c.Start()
+	defer h2c.Close()
+	h2 := newTLSTestServer(t, true, grpcHealthHandler(t))
+
+	tests := []struct {
+		name     string
+		url      string
+		service  string
+		wantKind errorKind // "" for success
+		wantSays string
+	}{
+		{name: "h2c", url: h2c.URL, wantSays: "Serving status: SERVING"},
+		{name: "tls", url: h2.URL, wantSays: "Serving status: SERVING"},
+		{name: "not serving", url: h2.URL, service: "down", wantKind: errCheckFailed, wantSays: "Serving status: NOT_SERVING"},
+		{name: "trailers only", url: h2c.URL, service: "nope", wantKind: errCheckFailed, wantSays: "gRPC status: 5 NOT_FOUND (unknown service)"},
+	}
+	for _, tt := range tests {
+		s, err := newSession(options{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		out := captureStdout(t, func() { err = s.grpcHealthCheck(tt.url, nil, "", tt.service, 5*time.Second) })
+		s.close()
+		if (err == nil) != (tt.wantKind == "") || err != nil && kindOf(err) != tt.wantKind {
+			t.Errorf("%s: grpcHealthCheck() = %v, want kind %q", tt.name, err, tt.wantKind)
+		}
+		if !strings.Contains(out, tt.wantSays) {
+			t.Errorf("%s: output lacks %q:\n%s", tt.name, tt.wantSays, out)
+		}
+	}
+
+	// An HTTP/1.1 server cannot be a gRPC server.
+	h1 := newTLSTestServer(t, false, http.HandlerFunc(helloHandler))
+	s, err := newSession(options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.close()
+	captureStdout(t, func() { err = s.grpcHealthCheck(h1.URL, nil, "", "", 5*time.Second) })
+	if kindOf(err) != errProtocol || !strings.Contains(err.Error(), "HTTP/2") {
+		t.Errorf("grpcHealthCheck() over HTTP/1.1 = %v, want a protocol error", err)
+	}
+}
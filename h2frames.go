@@ -0,0 +1,155 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+const h2ClientPreface = "PRI * HTTP/2.0\r\n\r\nSM\r\n\r\n"
+
+var h2FrameTypes = []string{"DATA", "HEADERS", "PRIORITY", "RST_STREAM", "SETTINGS", "PUSH_PROMISE", "PING", "GOAWAY", "WINDOW_UPDATE", "CONTINUATION"}
+
+var h2Settings = map[uint16]string{
+	1: "HEADER_TABLE_SIZE",
+	2: "ENABLE_PUSH",
+	3: "MAX_CONCURRENT_STREAMS",
+	4: "INITIAL_WINDOW_SIZE",
+	5: "MAX_FRAME_SIZE",
+	6: "MAX_HEADER_LIST_SIZE",
+	8: "ENABLE_CONNECT_PROTOCOL",
+	9: "NO_RFC7540_PRIORITIES",
+}
+
+var h2ErrorCodes = []string{"NO_ERROR", "PROTOCOL_ERROR", "INTERNAL_ERROR", "FLOW_CONTROL_ERROR", "SETTINGS_TIMEOUT", "STREAM_CLOSED", "FRAME_SIZE_ERROR", "REFUSED_STREAM", "CANCEL", "COMPRESSION_ERROR", "CONNECT_ERROR", "ENHANCE_YOUR_CALM", "INADEQUATE_SECURITY", "HTTP_1_1_REQUIRED"}
+
+const (
+	h2FlagEndStream  = 0x1
+	h2FlagAck        = 0x1
+	h2FlagEndHeaders = 0x4
+	h2FlagPadded     = 0x8
+	h2FlagPriority   = 0x20
+)
+
+// h2FrameLog decodes the HTTP/2 frames crossing a connection and prints one line per frame, with the time since the
+// TLS handshake completed. Header blocks are HPACK-compressed and only their size is shown.
+type h2FrameLog struct {
+	log   *verboseLog
+	start time.Time
+
+	mu      sync.Mutex
+	preface int // client preface bytes still to skip on the write side
+	out, in bytes.Buffer
+}
+
+func newH2FrameLog(log *verboseLog) *h2FrameLog {
+	return &h2FrameLog{log: log, start: time.Now(), preface: len(h2ClientPreface)}
+}
+
+func (l *h2FrameLog) wrote(p []byte) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.preface > 0 {
+		n := min(l.preface, len(p))
+		l.preface -= n
+		p = p[n:]
+	}
+	l.out.Write(p)
+	l.drain(&l.out, "->")
+}
+
+func (l *h2FrameLog) read(p []byte) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.in.Write(p)
+	l.drain(&l.in, "<-")
+}
+
+func (l *h2FrameLog) drain(buf *bytes.Buffer, dir string) {
+	for buf.Len() >= 9 {
+		hdr := buf.Bytes()[:9]
+		length := int(hdr[0])<<16 | int(hdr[1])<<8 | int(hdr[2])
+		if buf.Len() < 9+length {
+			return
+		}
+		typ, flags := hdr[3], hdr[4]
+		stream := binary.BigEndian.Uint32(hdr[5:9]) & 0x7fffffff
+		buf.Next(9)
+		payload := buf.Next(length)
+
+		elapsed := float64(time.Since(l.start).Microseconds()) / 1000
+		l.log.printf("h2 %9.3fms %s %s stream=%d len=%d%s", elapsed, dir, h2FrameTypeName(typ), stream, length, h2FrameDetails(typ, flags, payload))
+	}
+}
+
+func h2FrameTypeName(typ byte) string {
+	if int(typ) < len(h2FrameTypes) {
+		return h2FrameTypes[typ]
+	}
+	return fmt.Sprintf("UNKNOWN(0x%x)", typ)
+}
+
+func h2ErrorName(code uint32) string {
+	if int(code) < len(h2ErrorCodes) {
+		return h2ErrorCodes[code]
+	}
+	return fmt.Sprintf("0x%x", code)
+}
+
+func h2FrameDetails(typ, flags byte, payload []byte) string {
+	var b strings.Builder
+	flag := func(mask byte, name string) {
+		if flags&mask != 0 {
+			b.WriteString(" " + name)
+		}
+	}
+	switch typ {
+	case 0: // DATA
+		flag(h2FlagEndStream, "END_STREAM")
+		flag(h2FlagPadded, "PADDED")
+	case 1: // HEADERS
+		flag(h2FlagEndStream, "END_STREAM")
+		flag(h2FlagEndHeaders, "END_HEADERS")
+		flag(h2FlagPadded, "PADDED")
+		flag(h2FlagPriority, "PRIORITY")
+	case 3: // RST_STREAM
+		if len(payload) >= 4 {
+			fmt.Fprintf(&b, " error=%s", h2ErrorName(binary.BigEndian.Uint32(payload)))
+		}
+	case 4: // SETTINGS
+		flag(h2FlagAck, "ACK")
+		for i := 0; i+6 <= len(payload); i += 6 {
+			id := binary.BigEndian.Uint16(payload[i:])
+			name, ok := h2Settings[id]
+			if !ok {
+				name = fmt.Sprintf("0x%x", id)
+			}
+			fmt.Fprintf(&b, " %s=%d", name, binary.BigEndian.Uint32(payload[i+2:]))
+		}
+	case 5: // PUSH_PROMISE
+		flag(h2FlagEndHeaders, "END_HEADERS")
+		if len(payload) >= 4 {
+			fmt.Fprintf(&b, " promised=%d", binary.BigEndian.Uint32(payload)&0x7fffffff)
+		}
+	case 6: // PING
+		flag(h2FlagAck, "ACK")
+		fmt.Fprintf(&b, " data=%x", payload)
+	case 7: // GOAWAY
+		if len(payload) >= 8 {
+			fmt.Fprintf(&b, " last_stream=%d error=%s", binary.BigEndian.Uint32(payload)&0x7fffffff, h2ErrorName(binary.BigEndian.Uint32(payload[4:])))
+			if len(payload) > 8 {
+				fmt.Fprintf(&b, " debug=%q", payload[8:])
+			}
+		}
+	case 8: // WINDOW_UPDATE
+		if len(payload) >= 4 {
+			fmt.Fprintf(&b, " increment=%d", binary.BigEndian.Uint32(payload)&0x7fffffff)
+		}
+	case 9: // CONTINUATION
+		flag(h2FlagEndHeaders, "END_HEADERS")
+	}
+	return b.String()
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"bytes"
+	"net/http"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+func TestH2FrameDetails(t *testing.T) {
+	tests := []struct {
+		name    string
+		typ     byte
+		flags   byte
+		payload []byte
+		want    string
+	}{
+		{"DATA", 0, h2FlagEndStream, []byte("hi"), " END_STREAM"},
+		{"HEADERS", 1, h2FlagEndHeaders | h2FlagPriority, nil, " END_HEADERS PRIORITY"},
+		{"RST_STREAM", 3, 0, []byte{0, 0, 0, 8}, " error=CANCEL"},
+		{"RST_STREAM unknown code", 3, 0, []byte{0, 0, 0, 0x20}, " error=0x20"},
+		{"SETTINGS", 4, 0, []byte{0, 3, 0, 0, 0, 100, 0, 0x10, 0, 0, 0, 1}, " MAX_CONCURRENT_STREAMS=100 0x10=1"},
+		{"SETTINGS ACK", 4, h2FlagAck, nil, " ACK"},
+		{"PUSH_PROMISE", 5, h2FlagEndHeaders, []byte{0x80, 0, 0, 2}, " END_HEADERS promised=2"},
+		{"PING", 6, h2FlagAck, []byte{1, 2, 3, 4, 5, 6, 7, 8}, " ACK data=0102030405060708"},
+		{"GOAWAY", 7, 0, []byte{0, 0, 0, 5, 0, 0, 0, 0xb, 'c', 'a', 'l', 'm'}, ` last_stream=5 error=ENHANCE_YOUR_CALM debug="calm"`},
+		{"GOAWAY truncated", 7, 0, []byte{0, 0, 0, 5}, ""},
+		{"WINDOW_UPDATE", 8, 0, []byte{0x80, 0, 0x10, 0}, " increment=4096"},
+		{"CONTINUATION", 9, h2FlagEndHeaders, nil, " END_HEADERS"},
+		{"unknown type", 0x20, 0xff, []byte{1}, ""},
+	}
+	for _, tt := range tests {
+		if got := h2FrameDetails(tt.typ, tt.flags, tt.payload); got != tt.want {
+			t.Errorf("%s: h2FrameDetails() = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func h2Frame(typ, flags byte, stream uint32, payload []byte) []byte {
+	n := len(payload)
+	hdr := []byte{byte(n >> 16), byte(n >> 8), byte(n), typ, flags, byte(stream >> 24), byte(stream >> 16), byte(stream >> 8), byte(stream)}
+	return append(hdr, payload...)
+}
+
+var h2LogTime = regexp.MustCompile(`h2 +[0-9.]+ms `)
+
+func TestH2FrameLog(t *testing.T) {
+	var buf bytes.Buffer
+	l := newH2FrameLog(newVerboseLog(&buf))
+
+	// The preface is skipped and frames are decoded however the writes and reads split them.
+	out := append([]byte(h2ClientPreface), h2Frame(4, 0, 0, nil)...)
+	out = append(out, h2Frame(1, h2FlagEndHeaders|h2FlagEndStream, 1, []byte{0x82, 0x87})...)
+	for _, n := range []int{10, 20, 5} {
+		l.wrote(out[:n])
+		out = out[n:]
+	}
+	l.wrote(out)
+	in := append(h2Frame(4, h2FlagAck, 0, nil), h2Frame(0x42, 0, 3, []byte("x"))...)
+	l.read(in[:12])
+	l.read(in[12:])
+
+	want := strings.Join([]string{
+		"-> SETTINGS stream=0 len=0",
+		"-> HEADERS stream=1 len=2 END_STREAM END_HEADERS",
+		"<- SETTINGS stream=0 len=0 ACK",
+		"<- UNKNOWN(0x42) stream=3 len=1",
+	}, "\n") + "\n"
+	if got := h2LogTime.ReplaceAllString(buf.String(), ""); got != want {
+		t.Errorf("frame log:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+func TestH2Frames(t *testing.T) {
+	srv := newTLSTestServer(t, true, http.HandlerFunc(helloHandler))
+	s, buf := verboseSession(t, options{h2Frames: true})
+	req, _ := http.NewRequest(http.MethodGet, srv.URL+"/", nil)
+	resp, body, err := s.fetch(req, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.Proto != "HTTP/2.0" || string(body) != "hello over HTTP/2.0" {
+		t.Fatalf("got %s %q, want an HTTP/2 response", resp.Proto, body)
+	}
+	s.close()
+
+	out := h2LogTime.ReplaceAllString(buf.String(), "")
+	for _, want := range []string{
+		"-> SETTINGS stream=0",
+		"-> HEADERS stream=1 len=",
+		"<- SETTINGS stream=0",
+		"<- HEADERS stream=1 len=",
+		"<- DATA stream=1 len=19",
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("frame log lacks %q:\n%s", want, out)
+		}
+	}
+}
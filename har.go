@@ -0,0 +1,364 @@
+package main
+
+import (
+	"bytes"
+	"crypto/tls"
+	"encoding/base64"
+	"encoding/json"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptrace"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+// The types below follow the HAR 1.2 spec (http://www.softwareishard.com/blog/har-12-spec/), leaving out the
+// optional parts this tool has nothing to put in.
+
+type harFile struct {
+	Log harLog `json:"log"`
+}
+
+type harLog struct {
+	Version string      `json:"version"`
+	Creator harCreator  `json:"creator"`
+	Entries []*harEntry `json:"entries"`
+}
+
+type harCreator struct {
+	Name    string `json:"name"`
+	Version string `json:"version"`
+}
+
+type harEntry struct {
+	StartedDateTime time.Time   `json:"startedDateTime"`
+	Time            float64     `json:"time"`
+	Request         harRequest  `json:"request"`
+	Response        harResponse `json:"response"`
+	Cache           struct{}    `json:"cache"`
+	Timings         harTimings  `json:"timings"`
+	ServerIPAddress string      `json:"serverIPAddress,omitempty"`
+	Connection      string      `json:"connection,omitempty"`
+	Comment         string      `json:"comment,omitempty"`
+}
+
+type harRequest struct {
+	Method      string         `json:"method"`
+	URL         string         `json:"url"`
+	HTTPVersion string         `json:"httpVersion"`
+	Cookies     []harCookie    `json:"cookies"`
+	Headers     []harNameValue `json:"headers"`
+	QueryString []harNameValue `json:"queryString"`
+	PostData    *harPostData   `json:"postData,omitempty"`
+	HeadersSize int            `json:"headersSize"`
+	BodySize    int64          `json:"bodySize"`
+}
+
+type harResponse struct {
+	Status      int            `json:"status"`
+	StatusText  string         `json:"statusText"`
+	HTTPVersion string         `json:"httpVersion"`
+	Cookies     []harCookie    `json:"cookies"`
+	Headers     []harNameValue `json:"headers"`
+	Content     harContent     `json:"content"`
+	RedirectURL string         `json:"redirectURL"`
+	HeadersSize int            `json:"headersSize"`
+	BodySize    int64          `json:"bodySize"`
+}
+
+type harNameValue struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+type harCookie struct {
+	Name     string     `json:"name"`
+	Value    string     `json:"value"`
+	Path     string     `json:"path,omitempty"`
+	Domain   string     `json:"domain,omitempty"`
+	Expires  *time.Time `json:"expires,omitempty"`
+	HTTPOnly bool       `json:"httpOnly,omitempty"`
+	Secure   bool       `json:"secure,omitempty"`
+}
+
+type harPostData struct {
+	MimeType string `json:"mimeType"`
+	Text     string `json:"text"`
+}
+
+type harContent struct {
+	Size     int64  `json:"size"`
+	MimeType string `json:"mimeType"`
+	Text     string `json:"text,omitempty"`
+	Encoding string `json:"encoding,omitempty"`
+}
+
+// harTimings are in milliseconds, with -1 for phases that did not happen (e.g. dns and connect on a reused
+// connection). As the spec asks, connect includes ssl.
+type harTimings struct {
+	Blocked float64 `json:"blocked"`
+	DNS     float64 `json:"dns"`
+	Connect float64 `json:"connect"`
+	Send    float64 `json:"send"`
+	Wait    float64 `json:"wait"`
+	Receive float64 `json:"receive"`
+	SSL     float64 `json:"ssl"`
+}
+
+// harRecorder is a RoundTripper that records every exchange passing through it, so redirects and repeats
+// followed by the http.Client each get their own entry.
+type harRecorder struct {
+	next       http.RoundTripper
+	withBodies bool
+
+	mu      sync.Mutex
+	entries []*harEntry
+}
+
+func newHARRecorder(next http.RoundTripper, withBodies bool) *harRecorder {
+	return &harRecorder{next: next, withBodies: withBodies}
+}
+
+func (h *harRecorder) RoundTrip(req *http.Request) (*http.Response, error) {
+	entry := &harEntry{
+		StartedDateTime: time.Now(),
+		Request: harRequest{
+			Method:      req.Method,
+			URL:         req.URL.String(),
+			HTTPVersion: req.Proto,
+			Cookies:     harCookies(req.Cookies()),
+			Headers:     harHeaders(req.Header),
+			QueryString: []harNameValue{},
+			HeadersSize: -1,
+			BodySize:    req.ContentLength,
+		},
+		// What a failed exchange is left with: status 0, and the error in the comment.
+		Response: harResponse{
+			HTTPVersion: req.Proto,
+			Cookies:     []harCookie{},
+			Headers:     []harNameValue{},
+			Content:     harContent{MimeType: "x-unknown"},
+			HeadersSize: -1,
+			BodySize:    -1,
+		},
+	}
+	for name, vals := range req.URL.Query() {
+		for _, v := range vals {
+			entry.Request.QueryString = append(entry.Request.QueryString, harNameValue{Name: name, Value: v})
+		}
+	}
+	if h.withBodies && req.GetBody != nil && req.ContentLength != 0 {
+		if body, err := req.GetBody(); err == nil {
+			data, _ := io.ReadAll(body)
+			body.Close()
+			entry.Request.PostData = &harPostData{MimeType: req.Header.Get("Content-Type"), Text: string(data)}
+		}
+	}
+	h.mu.Lock()
+	h.entries = append(h.entries, entry)
+	h.mu.Unlock()
+
+	timer := &harTimer{entry: entry, start: entry.StartedDateTime}
+	req = req.WithContext(httptrace.WithClientTrace(req.Context(), timer.clientTrace()))
+	resp, err := h.next.RoundTrip(req)
+	if err != nil {
+		entry.Comment = err.Error()
+		timer.finish(time.Now())
+		return nil, err
+	}
+
+	entry.Response = harResponse{
+		Status:      resp.StatusCode,
+		StatusText:  http.StatusText(resp.StatusCode),
+		HTTPVersion: resp.Proto,
+		Cookies:     harCookies(resp.Cookies()),
+		Headers:     harHeaders(resp.Header),
+		Content:     harContent{MimeType: resp.Header.Get("Content-Type")},
+		RedirectURL: resp.Header.Get("Location"),
+		HeadersSize: -1,
+	}
+	if resp.StatusCode == http.StatusSwitchingProtocols {
+		// The body is the upgraded connection, which the caller needs to keep writable.
+		timer.finish(time.Now())
+		return resp, nil
+	}
+	resp.Body = &harBody{ReadCloser: resp.Body, timer: timer, keep: h.withBodies}
+	return resp, nil
+}
+
+// WriteFile writes the recorded exchanges as a HAR 1.2 document.
+func (h *harRecorder) WriteFile(path string) error {
+	h.mu.Lock()
+	doc := harFile{Log: harLog{
+		Version: "1.2",
+		Creator: harCreator{Name: "gotest", Version: "1"},
+		Entries: h.entries,
+	}}
+	data, err := json.MarshalIndent(doc, "", "  ")
+	h.mu.Unlock()
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, data, 0o644)
+}
+
+// harTimer fills in an entry's timings and dialed address from httptrace events.
+type harTimer struct {
+	entry *harEntry
+	start time.Time
+
+	mu                        sync.Mutex
+	dnsStart, dnsDone         time.Time
+	connectStart, connectDone time.Time
+	tlsStart, tlsDone         time.Time
+	gotConn, wroteRequest     time.Time
+	firstByte                 time.Time
+}
+
+func (t *harTimer) clientTrace() *httptrace.ClientTrace {
+	now := func(field *time.Time) func() {
+		return func() {
+			t.mu.Lock()
+			*field = time.Now()
+			t.mu.Unlock()
+		}
+	}
+	return &httptrace.ClientTrace{
+		DNSStart:          func(httptrace.DNSStartInfo) { now(&t.dnsStart)() },
+		DNSDone:           func(httptrace.DNSDoneInfo) { now(&t.dnsDone)() },
+		ConnectStart:      func(string, string) { now(&t.connectStart)() },
+		ConnectDone:       func(string, string, error) { now(&t.connectDone)() },
+		TLSHandshakeStart: func() { now(&t.tlsStart)() },
+		TLSHandshakeDone:  func(tls.ConnectionState, error) { now(&t.tlsDone)() },
+		GotConn: func(info httptrace.GotConnInfo) {
+			now(&t.gotConn)()
+			t.mu.Lock()
+			defer t.mu.Unlock()
+			if addr, ok := info.Conn.RemoteAddr().(*net.TCPAddr); ok {
+				// This is the address the overriding DialContext actually dialed, not what DNS says for the URL host.
+				t.entry.ServerIPAddress = addr.IP.String()
+			}
+			if addr, ok := info.Conn.LocalAddr().(*net.TCPAddr); ok {
+				t.entry.Connection = strconv.Itoa(addr.Port)
+			}
+		},
+		WroteRequest:         func(httptrace.WroteRequestInfo) { now(&t.wroteRequest)() },
+		GotFirstResponseByte: now(&t.firstByte),
+	}
+}
+
+func (t *harTimer) finish(end time.Time) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	ms := func(from, to time.Time) float64 {
+		if from.IsZero() || to.IsZero() {
+			return -1
+		}
+		return float64(to.Sub(from).Microseconds()) / 1000
+	}
+	connectEnd := t.connectDone
+	if !t.tlsDone.IsZero() {
+		connectEnd = t.tlsDone
+	}
+	timings := harTimings{
+		DNS:     ms(t.dnsStart, t.dnsDone),
+		Connect: ms(t.connectStart, connectEnd),
+		SSL:     ms(t.tlsStart, t.tlsDone),
+		Send:    ms(t.gotConn, t.wroteRequest),
+		Wait:    ms(t.wroteRequest, t.firstByte),
+		Receive: ms(t.firstByte, end),
+	}
+	blocked := ms(t.start, t.gotConn)
+	for _, phase := range []float64{timings.DNS, timings.Connect} {
+		if phase > 0 && blocked > 0 {
+			blocked -= phase
+		}
+	}
+	if blocked < 0 {
+		blocked = -1
+	}
+	timings.Blocked = blocked
+
+	t.entry.Timings = timings
+	t.entry.Time = 0
+	for _, phase := range []float64{timings.Blocked, timings.DNS, timings.Connect, timings.Send, timings.Wait, timings.Receive} {
+		if phase > 0 {
+			t.entry.Time += phase
+		}
+	}
+}
+
+// harBody completes its entry once the client is done reading the response body.
+type harBody struct {
+	io.ReadCloser
+	timer *harTimer
+	keep  bool
+
+	buf  bytes.Buffer
+	size int64
+	done bool
+}
+
+func (b *harBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	b.size += int64(n)
+	if b.keep {
+		b.buf.Write(p[:n])
+	}
+	if err == io.EOF {
+		b.complete()
+	}
+	return n, err
+}
+
+func (b *harBody) Close() error {
+	b.complete()
+	return b.ReadCloser.Close()
+}
+
+func (b *harBody) complete() {
+	if b.done {
+		return
+	}
+	b.done = true
+	b.timer.finish(time.Now())
+
+	resp := &b.timer.entry.Response
+	resp.BodySize = b.size
+	resp.Content.Size = b.size
+	if b.keep {
+		if utf8.Valid(b.buf.Bytes()) {
+			resp.Content.Text = b.buf.String()
+		} else {
+			resp.Content.Text = base64.StdEncoding.EncodeToString(b.buf.Bytes())
+			resp.Content.Encoding = "base64"
+		}
+	}
+}
+
+func harHeaders(h http.Header) []harNameValue {
+	out := []harNameValue{}
+	for name, vals := range h {
+		for _, v := range vals {
+			out = append(out, harNameValue{Name: name, Value: v})
+		}
+	}
+	return out
+}
+
+func harCookies(cookies []*http.Cookie) []harCookie {
+	out := []harCookie{}
+	for _, c := range cookies {
+		hc := harCookie{Name: c.Name, Value: c.Value, Path: c.Path, Domain: c.Domain, HTTPOnly: c.HttpOnly, Secure: c.Secure}
+		if !c.Expires.IsZero() {
+			expires := c.Expires
+			hc.Expires = &expires
+		}
+		out = append(out, hc)
+	}
+	return out
+}
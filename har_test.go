@@ -0,0 +1,119 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+type failingTransport struct{}
+
+func (failingTransport) RoundTrip(*http.Request) (*http.Response, error) {
+	return nil, errors.New("connection refused")
+}
+
+// readHAR writes what h recorded and decodes it generically, to see the JSON as an importer would.
+func readHAR(t *testing.T, h *harRecorder) []map[string]any {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "out.har")
+	if err := h.WriteFile(path); err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var doc struct {
+		Log struct {
+			Entries []map[string]any `json:"entries"`
+		} `json:"log"`
+	}
+	if err := json.Unmarshal(data, &doc); err != nil {
+		t.Fatal(err)
+	}
+	return doc.Log.Entries
+}
+
+func TestHARRecorderFailedExchange(t *testing.T) {
+	h := newHARRecorder(failingTransport{}, false)
+	req, _ := http.NewRequest(http.MethodGet, "http://example.com/?q=1", nil)
+	if _, err := h.RoundTrip(req); err == nil {
+		t.Fatal("RoundTrip() succeeded")
+	}
+
+	entries := readHAR(t, h)
+	if len(entries) != 1 {
+		t.Fatalf("got %d entries, want 1", len(entries))
+	}
+	resp := entries[0]["response"].(map[string]any)
+	for _, field := range []string{"headers", "cookies"} {
+		if _, ok := resp[field].([]any); !ok {
+			t.Errorf("response %s = %v, want an array", field, resp[field])
+		}
+	}
+	if resp["status"] != 0.0 {
+		t.Errorf("response status = %v, want 0", resp["status"])
+	}
+	if v, _ := resp["httpVersion"].(string); v == "" {
+		t.Errorf("response httpVersion is empty")
+	}
+	if c, _ := entries[0]["comment"].(string); !strings.Contains(c, "connection refused") {
+		t.Errorf("comment = %q, want the error", c)
+	}
+}
+
+func TestHARRecorderExchange(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.SetCookie(w, &http.Cookie{Name: "a", Value: "b", HttpOnly: true})
+		w.Header().Set("Content-Type", "text/plain")
+		io.WriteString(w, "hello "+r.URL.Query().Get("name"))
+	}))
+	defer srv.Close()
+
+	h := newHARRecorder(http.DefaultTransport, true)
+	req, _ := http.NewRequest(http.MethodPost, srv.URL+"/?name=har", strings.NewReader("payload"))
+	req.Header.Set("Content-Type", "text/plain")
+	resp, err := h.RoundTrip(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	io.ReadAll(resp.Body)
+	resp.Body.Close()
+
+	entries := readHAR(t, h)
+	if len(entries) != 1 {
+		t.Fatalf("got %d entries, want 1", len(entries))
+	}
+	e := entries[0]
+	request := e["request"].(map[string]any)
+	if got := request["postData"].(map[string]any)["text"]; got != "payload" {
+		t.Errorf("postData text = %v, want payload", got)
+	}
+	if qs := request["queryString"].([]any); len(qs) != 1 {
+		t.Errorf("queryString = %v, want one parameter", qs)
+	}
+	response := e["response"].(map[string]any)
+	if response["status"] != 200.0 || response["httpVersion"] != "HTTP/1.1" {
+		t.Errorf("response status %v %v, want 200 HTTP/1.1", response["status"], response["httpVersion"])
+	}
+	if cookies := response["cookies"].([]any); len(cookies) != 1 {
+		t.Errorf("response cookies = %v, want one", cookies)
+	}
+	content := response["content"].(map[string]any)
+	if content["text"] != "hello har" || content["size"] != 9.0 {
+		t.Errorf("content = %v, want the 9 byte body", content)
+	}
+	if e["serverIPAddress"] != "127.0.0.1" {
+		t.Errorf("serverIPAddress = %v, want 127.0.0.1", e["serverIPAddress"])
+	}
+	timings := e["timings"].(map[string]any)
+	if timings["wait"].(float64) < 0 {
+		t.Errorf("wait timing = %v, want it measured", timings["wait"])
+	}
+}
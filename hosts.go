@@ -0,0 +1,63 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"net/netip"
+	"os"
+	"strings"
+)
+
+// applyHostsFiles turns the -hosts files into dial overrides keyed by bare host, so every port of a listed name is
+// dialed at its address while TLS still validates against the name. Hosts files are given on the command line, so
+// they take precedence over the overrides of a profile.
+func applyHostsFiles(opts *options) error {
+	if len(opts.hostsFiles) == 0 {
+		return nil
+	}
+	overrides := dialOverrides{}
+	for _, path := range opts.hostsFiles {
+		if err := readHostsFile(path, overrides); err != nil {
+			return newError(errBadInput, "reading hosts file failed: %v", err)
+		}
+	}
+	for k, v := range opts.overrides {
+		if _, ok := overrides[k]; !ok {
+			overrides[k] = v
+		}
+	}
+	opts.overrides = overrides
+	return nil
+}
+
+// readHostsFile adds the names in the /etc/hosts format file at path to overrides. As with /etc/hosts, the first
+// address listed for a name wins, also across files.
+func readHostsFile(path string, overrides dialOverrides) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for lineNo := 1; scanner.Scan(); lineNo++ {
+		line, _, _ := strings.Cut(scanner.Text(), "#")
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+		if len(fields) < 2 {
+			return fmt.Errorf("%s:%d: expected an address followed by names", path, lineNo)
+		}
+		if _, err := netip.ParseAddr(fields[0]); err != nil {
+			return fmt.Errorf("%s:%d: bad address %q", path, lineNo, fields[0])
+		}
+		for _, name := range fields[1:] {
+			key := overrideKey(name)
+			if _, ok := overrides[key]; !ok {
+				overrides[key] = fields[0]
+			}
+		}
+	}
+	return scanner.Err()
+}
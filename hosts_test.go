@@ -0,0 +1,78 @@
+package main
+
+import (
+	"maps"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func writeHostsFile(t *testing.T, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "hosts")
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestReadHostsFile(t *testing.T) {
+	tests := []struct {
+		name    string
+		file    string
+		want    dialOverrides
+		wantErr bool
+	}{
+		{
+			name: "aliases, comments and blank lines",
+			file: "# staging\n\n10.0.0.1 api.example.com api  # primary\n\t::1\tlocal.example.com\n",
+			want: dialOverrides{"api.example.com": "10.0.0.1", "api": "10.0.0.1", "local.example.com": "::1"},
+		},
+		{
+			name: "first address wins",
+			file: "10.0.0.1 a.example.com\n10.0.0.2 a.example.com b.example.com\n",
+			want: dialOverrides{"a.example.com": "10.0.0.1", "b.example.com": "10.0.0.2"},
+		},
+		{
+			name: "names are case-insensitive and may end in a dot",
+			file: "10.0.0.1 API.Example.com. Api\n10.0.0.2 api.example.com\n",
+			want: dialOverrides{"api.example.com": "10.0.0.1", "api": "10.0.0.1"},
+		},
+		{name: "address only", file: "10.0.0.1\n", wantErr: true},
+		{name: "bad address", file: "10.0.0.300 a.example.com\n", wantErr: true},
+		{name: "name first", file: "a.example.com 10.0.0.1\n", wantErr: true},
+	}
+	for _, tt := range tests {
+		got := dialOverrides{}
+		err := readHostsFile(writeHostsFile(t, tt.file), got)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("%s: error = %v, want error %v", tt.name, err, tt.wantErr)
+			continue
+		}
+		if !tt.wantErr && !maps.Equal(got, tt.want) {
+			t.Errorf("%s: overrides = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestApplyHostsFiles(t *testing.T) {
+	first := writeHostsFile(t, "10.0.0.1 a.example.com\n")
+	second := writeHostsFile(t, "10.0.0.2 A.EXAMPLE.COM B.example.com\n")
+	opts := options{
+		hostsFiles: []string{first, second},
+		overrides:  dialOverrides{"b.example.com": "10.9.9.9", "c.example.com:443": "10.0.0.3:8443"},
+	}
+	if err := applyHostsFiles(&opts); err != nil {
+		t.Fatal(err)
+	}
+	// Earlier files win, and hosts files win over the profile.
+	want := dialOverrides{"a.example.com": "10.0.0.1", "b.example.com": "10.0.0.2", "c.example.com:443": "10.0.0.3:8443"}
+	if !maps.Equal(opts.overrides, want) {
+		t.Errorf("overrides = %v, want %v", opts.overrides, want)
+	}
+
+	opts = options{hostsFiles: []string{filepath.Join(t.TempDir(), "missing")}}
+	if err := applyHostsFiles(&opts); kindOf(err) != errBadInput {
+		t.Errorf("applyHostsFiles() with a missing file = %v, want bad input", err)
+	}
+}
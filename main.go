@@ -1,90 +1,908 @@
 package main
 
 import (
+	"bytes"
 	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"flag"
 	"fmt"
 	"io"
-	"log"
+	"maps"
 	"net"
 	"net/http"
+	"net/http/httptrace"
+	"net/textproto"
 	"net/url"
 	"os"
+	"slices"
 	"strings"
 	"time"
 )
 
 type dialOverrideKey struct{}
 
-func withDialOverride(ctx context.Context, addr string) context.Context {
-	return context.WithValue(ctx, dialOverrideKey{}, addr)
+// dialOverrides maps the "host:port" a request would normally dial to the address that is dialed instead.
+// Keying by the original address keeps an override from leaking onto other hosts reached via redirects.
+// Entries may also be keyed by bare host, and targets without a port keep the port being dialed. Keys are stored as
+// overrideKey returns them.
+type dialOverrides map[string]string
+
+// overrideKey normalizes the host of a "host:port" or bare host key, since DNS names are case-insensitive and may be
+// written with a trailing root dot.
+func overrideKey(key string) string {
+	host, port, err := net.SplitHostPort(key)
+	if err != nil {
+		return strings.TrimSuffix(strings.ToLower(key), ".")
+	}
+	return net.JoinHostPort(strings.TrimSuffix(strings.ToLower(host), "."), port)
+}
+
+// set adds an override for key, replacing one for the same name written differently.
+func (o dialOverrides) set(key, target string) {
+	o[overrideKey(key)] = target
 }
 
-func dialOverrideFromContext(ctx context.Context) (string, bool) {
-	val, ok := ctx.Value(dialOverrideKey{}).(string)
-	return val, ok
+func (o dialOverrides) lookup(addr string) (string, bool) {
+	addr = overrideKey(addr)
+	target, ok := o[addr]
+	host, port, err := net.SplitHostPort(addr)
+	if !ok && err == nil {
+		target, ok = o[host]
+	}
+	if !ok {
+		return "", false
+	}
+	if _, _, err := net.SplitHostPort(target); err != nil && port != "" {
+		target = net.JoinHostPort(strings.Trim(target, "[]"), port)
+	}
+	return target, true
+}
+
+func withDialOverrides(ctx context.Context, overrides dialOverrides) context.Context {
+	return context.WithValue(ctx, dialOverrideKey{}, overrides)
+}
+
+func dialOverridesFromContext(ctx context.Context) dialOverrides {
+	val, _ := ctx.Value(dialOverrideKey{}).(dialOverrides)
+	return val
+}
+
+type http1OnlyKey struct{}
+
+// withHTTP1Only makes HTTPS requests offer only http/1.1 in ALPN, for requests such as WebSocket upgrades that HTTP/2 cannot
+// carry. The transport takes care of this itself only for TLS connections it sets up.
+func withHTTP1Only(ctx context.Context) context.Context {
+	return context.WithValue(ctx, http1OnlyKey{}, true)
+}
+
+type bareRequestKey struct{}
+
+// withBareRequest makes session.do send a request as built, without the session's cookies and default headers. It is
+// for CORS preflights, which browsers send that way, and for requests to hosts other than the target, such as OCSP
+// responders, which must not see the target's credentials.
+func withBareRequest(ctx context.Context) context.Context {
+	return context.WithValue(ctx, bareRequestKey{}, true)
+}
+
+type h2cKey struct{}
+
+// withH2C sends a cleartext request as HTTP/2 with prior knowledge instead of HTTP/1.1, as plaintext gRPC needs.
+func withH2C(ctx context.Context) context.Context {
+	return context.WithValue(ctx, h2cKey{}, true)
 }
 
 var (
+	sharedTLSConfig = &tls.Config{}
 	sharedTransport = &http.Transport{
 		ForceAttemptHTTP2: true,
+		TLSClientConfig:   sharedTLSConfig,
+		DialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
+			conn, err := dialOverridden(ctx, network, addr)
+			if err != nil {
+				return nil, err
+			}
+			return connConfigFromContext(ctx).wrap(conn, ""), nil
+		},
+		DialTLSContext: dialTLS,
+	}
+	h2cTransport = &http.Transport{
+		Protocols: h2cProtocols(),
 		DialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
-			if override, ok := dialOverrideFromContext(ctx); ok {
-				addr = override
+			conn, err := dialOverridden(ctx, network, addr)
+			if err != nil {
+				return nil, err
 			}
-			return (&net.Dialer{Timeout: 500 * time.Millisecond}).DialContext(ctx, network, addr)
+			return connConfigFromContext(ctx).wrap(conn, "h2"), nil
+		},
+	}
+
+	// tappedH1Transport and tappedH2Transport carry HTTPS requests whose plaintext is observed. Their connections come
+	// from dialTappedTLS already handshaken and wrapped, which the transport cannot tell from cleartext ones, so each
+	// speaks one protocol with prior knowledge.
+	tappedH1Transport = &http.Transport{
+		Protocols: http1Protocols(),
+		DialTLSContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
+			return connConfigFromContext(ctx).dialTappedTLS(ctx, network, addr, "http/1.1")
+		},
+	}
+	tappedH2Transport = &http.Transport{
+		Protocols: h2cProtocols(),
+		DialTLSContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
+			return connConfigFromContext(ctx).dialTappedTLS(ctx, network, addr, "h2")
 		},
 	}
 )
 
+func h2cProtocols() *http.Protocols {
+	var p http.Protocols
+	p.SetUnencryptedHTTP2(true)
+	return &p
+}
+
+func http1Protocols() *http.Protocols {
+	var p http.Protocols
+	p.SetHTTP1(true)
+	return &p
+}
+
+// baseTransport is the end of every session's RoundTripper chain. It sends requests marked withH2C over h2cTransport,
+// HTTPS requests of sessions that observe plaintext over the tapped transport for the server's protocol, and
+// everything else over sharedTransport.
+type baseTransport struct{}
+
+func (baseTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	ctx := req.Context()
+	if ctx.Value(h2cKey{}) != nil {
+		return h2cTransport.RoundTrip(req)
+	}
+	// Through a proxy the transport sets up TLS after CONNECT itself, so there is nothing to tap.
+	if cc := connConfigFromContext(ctx); cc.tapsTLS() && req.URL.Scheme == "https" && sharedTransport.Proxy == nil {
+		if ctx.Value(http1OnlyKey{}) != nil {
+			return tappedH1Transport.RoundTrip(req)
+		}
+		port := req.URL.Port()
+		if port == "" {
+			port = "443"
+		}
+		proto, err := cc.tlsProtocol(ctx, net.JoinHostPort(req.URL.Hostname(), port))
+		if err != nil {
+			return nil, err
+		}
+		if proto == "h2" {
+			return tappedH2Transport.RoundTrip(req)
+		}
+		return tappedH1Transport.RoundTrip(req)
+	}
+	return sharedTransport.RoundTrip(req)
+}
+
+// checkProxyOverrides rejects dial overrides when requests go through a proxy, which resolves and dials the URL host
+// itself and would silently ignore them.
+func checkProxyOverrides(opts options, overrides dialOverrides) error {
+	if opts.proxy != "" && len(overrides) > 0 {
+		return newError(errBadInput, "-proxy cannot be combined with an ip argument, -hosts or dial overrides from a profile or scenario")
+	}
+	return nil
+}
+
+func dialOverridden(ctx context.Context, network, addr string) (net.Conn, error) {
+	cc := connConfigFromContext(ctx)
+	if override, ok := dialOverridesFromContext(ctx).lookup(addr); ok {
+		cc.logf("* Dial override: %s -> %s", addr, override)
+		addr = override
+	}
+	cc.logf("* Connecting to %s", addr)
+	conn, err := cc.dialContext(ctx, network, addr)
+	if err != nil {
+		return nil, err
+	}
+	cc.logf("* Connected to %s from %s", conn.RemoteAddr(), conn.LocalAddr())
+	return conn, nil
+}
+
+// dialTLS sets up TLS itself, instead of leaving it to the transport, so that certificates can be validated against
+// the URL host and pinned even when the dial is overridden. The handshake is still driven by the transport so its
+// httptrace events and timings stay accurate.
+func dialTLS(ctx context.Context, network, addr string) (net.Conn, error) {
+	conn, cfg, err := dialTLSConfig(ctx, network, addr)
+	if err != nil {
+		return nil, err
+	}
+	if ctx.Value(http1OnlyKey{}) != nil {
+		cfg.NextProtos = []string{"http/1.1"}
+	}
+	return tls.Client(conn, cfg), nil
+}
+
+// dialTLSConfig dials addr and returns the TLS config for the connection. The server name comes from the original
+// addr, so certificate validation is against the URL host even when the dial is overridden.
+func dialTLSConfig(ctx context.Context, network, addr string) (net.Conn, *tls.Config, error) {
+	conn, err := dialOverridden(ctx, network, addr)
+	if err != nil {
+		return nil, nil, err
+	}
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		conn.Close()
+		return nil, nil, err
+	}
+	cfg := sharedTLSConfig.Clone()
+	if cfg.ServerName == "" {
+		cfg.ServerName = host
+	}
+	if len(cfg.NextProtos) == 0 {
+		cfg.NextProtos = []string{"h2", "http/1.1"}
+	}
+	cc := connConfigFromContext(ctx)
+	if cc != nil && cc.pins != nil {
+		target, _ := dialOverridesFromContext(ctx).lookup(addr)
+		cfg.VerifyConnection = func(cs tls.ConnectionState) error {
+			return cc.pins.check(addr, target, cs.PeerCertificates[0])
+		}
+	}
+	return conn, cfg, nil
+}
+
+type options struct {
+	cookieFile      string
+	followRedirects bool
+	count           int
+	scenarioFile    string
+	harFile         string
+	harBodies       bool
+	verbose         bool
+	h2Frames        bool
+	keyLogFile      string
+	retries         int
+	retryBackoff    time.Duration
+	retryMaxBackoff time.Duration
+	retryStatus     string
+	retryOn         string
+	retryUnsafe     bool
+	sourceIP        string
+	sourcePort      string
+	device          string
+	fwmark          int
+	tcpInfo         bool
+
+	keepAlive         time.Duration
+	keepAliveInterval time.Duration
+	keepAliveCount    int
+	noDelay           bool
+	fastOpen          bool
+	congestion        string
+	sndBuf, rcvBuf    int
+
+	byteRange string
+	output    string
+	resume    bool
+	cache     bool
+
+	audit       bool
+	auditPolicy string
+
+	corsOrigin      string
+	corsMethod      string
+	corsHeaders     string
+	corsCredentials bool
+	corsActual      bool
+
+	wsMessage string
+	wsTimeout time.Duration
+
+	grpcHealth  bool
+	grpcService string
+	grpcTimeout time.Duration
+
+	stream         bool
+	streamEvents   int
+	streamDuration time.Duration
+
+	method         string
+	dataFile       string
+	expectContinue time.Duration
+
+	tlsScan bool
+
+	revocation bool
+	ocspURL    string
+	crlURL     string
+
+	pinFile string
+	pinMode string
+
+	certCompare bool
+
+	configFile     string
+	profile        string
+	headers        []string
+	hostsFiles     []string
+	overrides      dialOverrides // from the profile and the hosts files
+	connectTimeout time.Duration
+	caCertFile     string
+	proxy          string
+}
+
 func main() {
-	if len(os.Args) < 2 || len(os.Args) > 3 {
-		fmt.Fprintf(os.Stderr, "usage: %s <url> [ip]\n", os.Args[0])
-		os.Exit(1)
+	var opts options
+	defineFlags(&opts)
+	flag.Usage = func() {
+		out := flag.CommandLine.Output()
+		fmt.Fprintf(out, "usage: %s [flags] <url> [ip]\n       %s [flags] -cert-compare <url> [ip...]\n       %s [flags] -scenario <file>\n",
+			os.Args[0], os.Args[0], os.Args[0])
+		flag.PrintDefaults()
+		fmt.Fprintf(out, "\nOn failure an \"Error: <kind>\" line is printed to stdout and the exit status is:\n")
+		for _, kind := range errorKinds {
+			fmt.Fprintf(out, "  %3d  %s\n", kind.exitCode(), kind)
+		}
+		fmt.Fprintf(out, "\nA -profile sets flags that are not given on the command line and whose environment variable, if any, is unset.\n")
+	}
+	flag.Parse()
+	if err := applyProfile(&opts); err != nil {
+		exitWithError(err)
+	}
+	if err := applyHostsFiles(&opts); err != nil {
+		exitWithError(err)
+	}
+
+	if err := run(opts); err != nil {
+		exitWithError(err)
 	}
+}
+
+// defineFlags registers the command line flags on flag.CommandLine, storing their values in opts.
+func defineFlags(opts *options) {
+	flag.StringVar(&opts.cookieFile, "cookies", "", "load cookies from and save them to a Netscape-format cookie `file`")
+	flag.BoolVar(&opts.followRedirects, "L", false, "follow redirects")
+	flag.IntVar(&opts.count, "n", 1, "number of times to send the request, sharing one cookie jar")
+	flag.StringVar(&opts.scenarioFile, "scenario", "", "run the multi-step scenario described in this JSON `file` instead of a single request")
+	flag.StringVar(&opts.harFile, "har", "", "write every request and response exchanged to this HAR `file`")
+	flag.BoolVar(&opts.harBodies, "har-bodies", false, "include request and response bodies in the HAR file")
+	flag.BoolVar(&opts.verbose, "v", false, "print the request and response headers as sent and received, and connection events, to stderr")
+	flag.BoolVar(&opts.h2Frames, "h2-frames", false, "log every HTTP/2 frame sent and received, with stream IDs and timing, to stderr")
+	flag.StringVar(&opts.keyLogFile, "keylog", os.Getenv("SSLKEYLOGFILE"), "append TLS session secrets to this `file` in NSS key log format, for decrypting captures (default $SSLKEYLOGFILE)")
+	flag.IntVar(&opts.retries, "retries", 0, "retry failed requests up to this many times")
+	flag.DurationVar(&opts.retryBackoff, "retry-backoff", 200*time.Millisecond, "base of the exponential, jittered wait between retries")
+	flag.DurationVar(&opts.retryMaxBackoff, "retry-max-backoff", 5*time.Second, "longest wait between retries")
+	flag.StringVar(&opts.retryStatus, "retry-status", "429,502,503,504", "comma-separated response status codes to retry")
+	flag.StringVar(&opts.retryOn, "retry-on", "dns,refused,timeout,reset,eof", "comma-separated error classes to retry: "+strings.Join(retryErrorClasses, ","))
+	flag.BoolVar(&opts.retryUnsafe, "retry-unsafe", false, "also retry non-idempotent requests (e.g. POST) that may have reached the server")
+	flag.StringVar(&opts.sourceIP, "source-ip", "", "local `ip` to dial from")
+	flag.StringVar(&opts.sourcePort, "source-port", "", "local `port` or port range (e.g. 40000-40100) to dial from")
+	flag.StringVar(&opts.device, "interface", "", "bind dials to this network `interface` with SO_BINDTODEVICE (Linux only)")
+	flag.IntVar(&opts.fwmark, "fwmark", 0, "set this SO_MARK firewall `mark` on dialed sockets, for policy routing (Linux only, needs CAP_NET_ADMIN)")
+	flag.BoolVar(&opts.tcpInfo, "tcp-info", false, "report RTT, retransmits, congestion window and MSS of each TCP connection from TCP_INFO (Linux only)")
+	flag.DurationVar(&opts.keepAlive, "keepalive", 0, "idle time before TCP keepalive probes start (0 for Go's default, negative to disable)")
+	flag.DurationVar(&opts.keepAliveInterval, "keepalive-interval", 0, "time between TCP keepalive probes (0 for Go's default)")
+	flag.IntVar(&opts.keepAliveCount, "keepalive-count", 0, "unanswered TCP keepalive probes before the connection is dropped (0 for Go's default)")
+	flag.BoolVar(&opts.noDelay, "nodelay", true, "set TCP_NODELAY, disabling Nagle's algorithm")
+	flag.BoolVar(&opts.fastOpen, "tcp-fastopen", false, "use TCP Fast Open when the server supports it (Linux only)")
+	flag.StringVar(&opts.congestion, "congestion", "", "TCP congestion control `algorithm`, e.g. cubic or bbr (Linux only)")
+	flag.IntVar(&opts.sndBuf, "sndbuf", 0, "socket send buffer size in `bytes` (Linux only)")
+	flag.IntVar(&opts.rcvBuf, "rcvbuf", 0, "socket receive buffer size in `bytes` (Linux only)")
+	flag.StringVar(&opts.byteRange, "range", "", "request this byte `range` (e.g. 0-99, 100- or -500) and verify the 206 Partial Content response")
+	flag.StringVar(&opts.output, "o", "", "write the response body to this `file`")
+	flag.BoolVar(&opts.resume, "resume", false, "continue a partial -o file with If-Range guarded range requests, also after interruptions (up to -retries times)")
+	flag.BoolVar(&opts.cache, "cache", false, "analyze caching headers of the response and check that revalidation yields 304 Not Modified")
+	flag.BoolVar(&opts.audit, "audit", false, "grade the security headers and cookie flags of the response, failing on policy violations; plain requests only, not other modes")
+	flag.StringVar(&opts.auditPolicy, "audit-policy", "", "JSON `file` setting which -audit checks fail, warn or are off, and their thresholds")
+	flag.StringVar(&opts.corsOrigin, "cors-origin", "", "simulate a browser on this `origin` (e.g. https://app.example.com): send a CORS preflight and explain whether it passes")
+	flag.StringVar(&opts.corsMethod, "cors-method", http.MethodGet, "method of the cross-origin request for -cors-origin")
+	flag.StringVar(&opts.corsHeaders, "cors-headers", "", "comma-separated non-safelisted request `headers` for -cors-origin (e.g. authorization,x-request-id)")
+	flag.BoolVar(&opts.corsCredentials, "cors-credentials", false, "simulate a request with credentials (cookies or HTTP auth) for -cors-origin")
+	flag.BoolVar(&opts.corsActual, "cors-actual", false, "after a passing preflight, also send the actual request and check its response")
+	flag.StringVar(&opts.wsMessage, "ws-message", "", "for ws:// and wss:// urls, send this text `message` after the handshake and wait for a reply")
+	flag.DurationVar(&opts.wsTimeout, "ws-timeout", 5*time.Second, "how long to wait for the WebSocket reply")
+	flag.BoolVar(&opts.grpcHealth, "grpc-health", false, "call grpc.health.v1.Health/Check on the server at the url, over TLS for https:// and cleartext HTTP/2 for http://")
+	flag.StringVar(&opts.grpcService, "grpc-service", "", "`service` name to ask -grpc-health about (default the whole server)")
+	flag.DurationVar(&opts.grpcTimeout, "grpc-timeout", 5*time.Second, "deadline of the gRPC health check")
+	flag.BoolVar(&opts.stream, "stream", false, "print the body as it arrives, Server-Sent Events one by one and anything else chunk by chunk, with timestamps")
+	flag.IntVar(&opts.streamEvents, "stream-events", 0, "stop streaming after this many events or chunks (implies -stream)")
+	flag.DurationVar(&opts.streamDuration, "stream-duration", 0, "stop streaming after this long (implies -stream)")
+	flag.StringVar(&opts.method, "X", "", "request `method` (default GET, or POST with -data-file); also used by -stream and -o")
+	flag.StringVar(&opts.dataFile, "data-file", "", "send the contents of this `file` as the request body")
+	flag.DurationVar(&opts.expectContinue, "expect-continue", 0, "send Expect: 100-continue with the body and wait this long for 100 Continue before sending it anyway")
+	flag.BoolVar(&opts.tlsScan, "tls-scan", false, "try each TLS version, cipher suite, key exchange group and ALPN protocol against the server and report which it accepts")
+	flag.BoolVar(&opts.revocation, "revocation", false, "check the server certificate against stapled OCSP, its OCSP responder and CRL, and report its Certificate Transparency SCTs")
+	flag.StringVar(&opts.ocspURL, "ocsp-responder", "", "ask this OCSP responder `url` instead of the one named in the certificate")
+	flag.StringVar(&opts.crlURL, "crl-url", "", "fetch the CRL from this `url` instead of the one named in the certificate")
+	flag.StringVar(&opts.pinFile, "pins", "", "pin the public key of each HTTPS server in this known_hosts style `file` on first contact and check it on later runs")
+	flag.StringVar(&opts.pinMode, "pin-mode", pinWarn, "what to do when a server presents a key other than the pinned one: warn, fail or update the pin")
+	flag.BoolVar(&opts.certCompare, "cert-compare", false, "compare the certificates presented by every ip given after the url, or every address of its host, and report outliers")
+	flag.StringVar(&opts.configFile, "config", defaultConfigPath(), "JSON `file` of named profiles; JSON rather than YAML, which would need a third-party module")
+	flag.StringVar(&opts.profile, "profile", os.Getenv("GOTEST_PROFILE"), "apply the flags, headers and dial overrides of this `profile` from the config file (default $GOTEST_PROFILE)")
+	flag.Func("H", "add a `header` (\"Name: value\") to every request to the target (not to CORS preflights or OCSP and CRL fetches), replacing a profile header of the same name; repeatable", func(v string) error {
+		opts.headers = append(opts.headers, v)
+		return nil
+	})
+	flag.Func("hosts", "dial the names in this /etc/hosts format `file` at their listed addresses instead of resolving them; repeatable, the first entry for a name wins", func(v string) error {
+		opts.hostsFiles = append(opts.hostsFiles, v)
+		return nil
+	})
+	flag.DurationVar(&opts.connectTimeout, "connect-timeout", dialTimeout, "how long to wait for a TCP connection to be established")
+	flag.StringVar(&opts.caCertFile, "cacert", "", "also trust the CA certificates in this PEM `file`")
+	flag.StringVar(&opts.proxy, "proxy", "", "send requests through this HTTP or SOCKS5 proxy `url`; HTTPS through a proxy bypasses -v, -h2-frames and -pins, and dial overrides are rejected")
+}
 
-	urlStr := os.Args[1]
-	var ip string
-	if len(os.Args) == 3 {
-		ip = os.Args[2]
+func run(opts options) error {
+	if opts.scenarioFile != "" {
+		if flag.NArg() != 0 {
+			return newError(errBadInput, "no url or ip arguments are allowed with -scenario")
+		}
+		return runScenarioFile(opts)
+	}
+	if flag.NArg() < 1 || flag.NArg() > 2 && !opts.certCompare {
+		return newError(errBadInput, "expected <url> [ip], got %d arguments", flag.NArg())
 	}
 
+	urlStr := flag.Arg(0)
+	ip := flag.Arg(1)
+
 	parsedURL, err := url.Parse(urlStr)
 	if err != nil {
-		log.Fatalf("parsing url failed: %v", err)
+		return newError(errBadInput, "parsing url failed: %v", err)
 	}
 	host := parsedURL.Hostname()
 	if host == "" {
-		log.Fatalf("url missing host: %q", urlStr)
+		return newError(errBadInput, "url missing host: %q", urlStr)
+	}
+	port, err := pickPort(parsedURL)
+	if err != nil {
+		return err
 	}
-	port := pickPort(parsedURL)
 
-	dialHost := host
+	// Keep TLS hostname validation intact by preserving the URL host while overriding the dial target when provided.
+	overrides := dialOverrides{}
+	maps.Copy(overrides, opts.overrides)
 	if ip != "" {
-		dialHost = ip
+		overrides.set(net.JoinHostPort(host, port), net.JoinHostPort(ip, port))
+	}
+	if err := checkProxyOverrides(opts, overrides); err != nil {
+		return err
+	}
+
+	var byteRange *byteRange
+	if opts.byteRange != "" {
+		r, err := parseByteRange(opts.byteRange)
+		if err != nil {
+			return &toolError{kind: errBadInput, err: err}
+		}
+		byteRange = &r
+	}
+	if opts.resume && (opts.output == "" || byteRange != nil) {
+		return newError(errBadInput, "-resume needs -o and cannot be combined with -range")
+	}
+	if opts.output != "" && opts.count > 1 {
+		return newError(errBadInput, "-o cannot be combined with -n")
+	}
+	method := strings.ToUpper(opts.method)
+	var data []byte
+	if opts.dataFile != "" {
+		if data, err = os.ReadFile(opts.dataFile); err != nil {
+			return newError(errBadInput, "reading request body failed: %v", err)
+		}
+		if method == "" {
+			method = http.MethodPost
+		}
+	}
+	if method == "" {
+		method = http.MethodGet
+	}
+	if opts.expectContinue > 0 && opts.dataFile == "" {
+		return newError(errBadInput, "-expect-continue needs a body from -data-file")
+	}
+	if opts.method != "" || opts.dataFile != "" {
+		switch mode := specialMode(opts, parsedURL.Scheme); mode {
+		case "", "-stream":
+		case "-o":
+			if method != http.MethodGet && (opts.resume || byteRange != nil) {
+				return newError(errBadInput, "-resume and -range with -o need a GET request, not %s", method)
+			}
+		default:
+			return newError(errBadInput, "-X and -data-file cannot be combined with %s", mode)
+		}
+	}
+	var policy *auditPolicy
+	if opts.audit || opts.auditPolicy != "" {
+		if policy, err = loadAuditPolicy(opts.auditPolicy); err != nil {
+			return newError(errBadInput, "loading audit policy failed: %v", err)
+		}
+		if mode := specialMode(opts, parsedURL.Scheme); mode != "" {
+			return newError(errBadInput, "-audit grades plain requests and cannot be combined with %s", mode)
+		}
 	}
-	dialAddr := net.JoinHostPort(dialHost, port)
 
-	req, err := http.NewRequest(http.MethodGet, urlStr, nil)
+	s, err := newSession(opts)
 	if err != nil {
-		log.Fatalf("building request failed: %v", err)
+		return err
 	}
-	// Keep TLS hostname validation intact by preserving the URL host while overriding the dial target when provided.
-	if ip != "" {
-		req = req.WithContext(withDialOverride(req.Context(), dialAddr))
+
+	if opts.cache {
+		if err := s.analyzeCache(urlStr, overrides, ip); err != nil {
+			s.close()
+			return err
+		}
+		return s.close()
+	}
+	if scheme := strings.ToLower(parsedURL.Scheme); scheme == "ws" || scheme == "wss" {
+		parsedURL.Scheme = scheme
+		if err := s.probeWebSocket(parsedURL.String(), overrides, ip, opts.wsMessage, opts.wsTimeout); err != nil {
+			s.close()
+			return err
+		}
+		return s.close()
+	}
+	if opts.revocation {
+		if scheme := strings.ToLower(parsedURL.Scheme); scheme != "https" && scheme != "wss" {
+			s.close()
+			return newError(errBadInput, "-revocation needs an https:// or wss:// url")
+		}
+		if err := s.checkRevocation(host, port, overrides, ip, opts.ocspURL, opts.crlURL); err != nil {
+			s.close()
+			return err
+		}
+		return s.close()
+	}
+	if opts.certCompare {
+		if scheme := strings.ToLower(parsedURL.Scheme); scheme != "https" && scheme != "wss" {
+			s.close()
+			return newError(errBadInput, "-cert-compare needs an https:// or wss:// url")
+		}
+		if err := s.compareCerts(host, port, flag.Args()[1:]); err != nil {
+			s.close()
+			return err
+		}
+		return s.close()
+	}
+	if opts.tlsScan {
+		if scheme := strings.ToLower(parsedURL.Scheme); scheme != "https" && scheme != "wss" {
+			s.close()
+			return newError(errBadInput, "-tls-scan needs an https:// or wss:// url")
+		}
+		if err := s.tlsScan(host, port, overrides, ip); err != nil {
+			s.close()
+			return err
+		}
+		return s.close()
+	}
+	if opts.grpcHealth {
+		if err := s.grpcHealthCheck(urlStr, overrides, ip, opts.grpcService, opts.grpcTimeout); err != nil {
+			s.close()
+			return err
+		}
+		return s.close()
+	}
+	if opts.stream || opts.streamEvents > 0 || opts.streamDuration > 0 {
+		if err := s.stream(urlStr, overrides, method, data, opts.streamEvents, opts.streamDuration); err != nil {
+			s.close()
+			return err
+		}
+		return s.close()
+	}
+	if opts.corsOrigin != "" {
+		cr := corsRequest{
+			origin:      opts.corsOrigin,
+			method:      strings.ToUpper(opts.corsMethod),
+			headers:     splitList(opts.corsHeaders),
+			credentials: opts.corsCredentials,
+		}
+		if err := s.checkCORS(urlStr, overrides, ip, cr, opts.corsActual); err != nil {
+			s.close()
+			return err
+		}
+		return s.close()
+	}
+	if opts.output != "" {
+		if err := s.download(urlStr, overrides, method, data, opts.output, opts.resume, byteRange); err != nil {
+			s.close()
+			return err
+		}
+		return s.close()
 	}
 
-	resp, err := sharedTransport.RoundTrip(req)
+	for i := 0; i < opts.count; i++ {
+		if opts.count > 1 {
+			fmt.Printf("Request %d/%d\n", i+1, opts.count)
+		}
+		req, err := s.newRequest(context.Background(), method, urlStr, data)
+		if err != nil {
+			s.close()
+			return err
+		}
+		if byteRange != nil {
+			req.Header.Set("Range", byteRange.header())
+		}
+		resp, body, err := s.fetch(req, overrides)
+		if err != nil {
+			s.close()
+			return err
+		}
+		printResponse(resp, body)
+		if byteRange != nil {
+			if _, err := checkRangeResponse(resp, *byteRange, int64(len(body))); err != nil {
+				s.close()
+				return err
+			}
+			fmt.Printf("Range check: OK\n")
+		}
+		if policy != nil {
+			if err := auditResponse(resp, policy); err != nil {
+				s.close()
+				return err
+			}
+		}
+	}
+
+	return s.close()
+}
+
+// specialMode names what selects a mode other than plain requests, in the order run checks them, or returns "" for
+// plain requests.
+func specialMode(opts options, scheme string) string {
+	switch scheme = strings.ToLower(scheme); {
+	case opts.cache:
+		return "-cache"
+	case scheme == "ws" || scheme == "wss":
+		return "a " + scheme + ":// url"
+	case opts.revocation:
+		return "-revocation"
+	case opts.certCompare:
+		return "-cert-compare"
+	case opts.tlsScan:
+		return "-tls-scan"
+	case opts.grpcHealth:
+		return "-grpc-health"
+	case opts.stream || opts.streamEvents > 0 || opts.streamDuration > 0:
+		return "-stream"
+	case opts.corsOrigin != "":
+		return "-cors-origin"
+	case opts.output != "":
+		return "-o"
+	}
+	return ""
+}
+
+// session holds the state shared by every request of one invocation.
+type session struct {
+	opts   options
+	jar    *cookieJar
+	har    *harRecorder
+	conn   *connConfig
+	client *http.Client
+	keyLog *os.File
+	header http.Header // added to every request that does not set the header itself
+}
+
+func newSession(opts options) (*session, error) {
+	dial, err := parseDialOptions(opts)
 	if err != nil {
-		log.Fatalf("request failed: %v", err)
+		return nil, &toolError{kind: errBadInput, err: err}
+	}
+	s := &session{opts: opts, jar: newCookieJar(), conn: &connConfig{dial: dial}}
+	if opts.tcpInfo {
+		s.conn.tcpConns = &tcpConnLog{}
+	}
+	stderr := newVerboseLog(os.Stderr)
+	if opts.verbose {
+		s.conn.verbose = stderr
+	}
+	if opts.h2Frames {
+		s.conn.h2Frames = stderr
+	}
+	if opts.cookieFile != "" {
+		if err := s.jar.Load(opts.cookieFile); err != nil {
+			return nil, newError(errBadInput, "loading cookies failed: %v", err)
+		}
+	}
+	for _, h := range opts.headers {
+		name, value, ok := strings.Cut(h, ":")
+		if !ok || strings.TrimSpace(name) == "" {
+			return nil, newError(errBadInput, "bad header %q, want \"Name: value\"", h)
+		}
+		if s.header == nil {
+			s.header = http.Header{}
+		}
+		s.header.Set(strings.TrimSpace(name), strings.TrimSpace(value))
+	}
+	if opts.caCertFile != "" {
+		pem, err := os.ReadFile(opts.caCertFile)
+		if err != nil {
+			return nil, newError(errBadInput, "reading CA certificates failed: %v", err)
+		}
+		roots, err := x509.SystemCertPool()
+		if err != nil {
+			roots = x509.NewCertPool()
+		}
+		if !roots.AppendCertsFromPEM(pem) {
+			return nil, newError(errBadInput, "no certificates found in %s", opts.caCertFile)
+		}
+		sharedTLSConfig.RootCAs = roots
+	}
+	if opts.proxy != "" {
+		u, err := url.Parse(opts.proxy)
+		if err != nil || u.Host == "" {
+			return nil, newError(errBadInput, "bad proxy url %q", opts.proxy)
+		}
+		sharedTransport.Proxy = http.ProxyURL(u)
+	}
+	if opts.pinFile != "" {
+		if s.conn.pins, err = newPinStore(opts.pinMode); err != nil {
+			return nil, &toolError{kind: errBadInput, err: err}
+		}
+		if err := s.conn.pins.Load(opts.pinFile); err != nil {
+			return nil, newError(errBadInput, "loading pins failed: %v", err)
+		}
+	}
+	var transport http.RoundTripper = baseTransport{}
+	if opts.harFile != "" {
+		s.har = newHARRecorder(transport, opts.harBodies)
+		transport = s.har
+	}
+	if s.conn.verbose != nil {
+		transport = &verboseTransport{next: transport, log: s.conn.verbose}
+	}
+	if opts.retries > 0 {
+		policy, err := newRetryPolicy(opts)
+		if err != nil {
+			return nil, newError(errBadInput, "invalid retry policy: %v", err)
+		}
+		transport = &retryTransport{next: transport, policy: policy}
+	}
+	sharedTransport.ExpectContinueTimeout = opts.expectContinue
+	h2cTransport.ExpectContinueTimeout = opts.expectContinue
+	tappedH1Transport.ExpectContinueTimeout = opts.expectContinue
+	tappedH2Transport.ExpectContinueTimeout = opts.expectContinue
+	if opts.keyLogFile != "" {
+		f, err := os.OpenFile(opts.keyLogFile, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0o600)
+		if err != nil {
+			return nil, newError(errBadInput, "opening key log failed: %v", err)
+		}
+		s.keyLog = f
+		sharedTLSConfig.KeyLogWriter = f
+	}
+	s.client = newClient(transport, s.jar, opts.followRedirects)
+	return s, nil
+}
+
+// close reports and persists whatever the session recorded. It is also called before exiting on a failure so that
+// cookies, HAR entries and connection statistics of the requests leading up to it are not lost.
+func (s *session) close() error {
+	if s.conn.tcpConns != nil {
+		s.conn.tcpConns.report()
+	}
+	sharedTransport.CloseIdleConnections()
+	h2cTransport.CloseIdleConnections()
+	tappedH1Transport.CloseIdleConnections()
+	tappedH2Transport.CloseIdleConnections()
+	s.conn.closeSpareConns()
+	if s.keyLog != nil {
+		s.keyLog.Close()
+	}
+	if s.opts.cookieFile != "" {
+		if err := s.jar.Save(s.opts.cookieFile); err != nil {
+			return fmt.Errorf("saving cookies failed: %w", err)
+		}
+	}
+	if s.conn.pins != nil {
+		if err := s.conn.pins.Save(s.opts.pinFile); err != nil {
+			return fmt.Errorf("saving pins failed: %w", err)
+		}
+	}
+	if s.har != nil {
+		if err := s.har.WriteFile(s.opts.harFile); err != nil {
+			return fmt.Errorf("writing HAR failed: %w", err)
+		}
+	}
+	return nil
+}
+
+func newClient(transport http.RoundTripper, jar http.CookieJar, followRedirects bool) *http.Client {
+	return &http.Client{
+		Transport: transport,
+		Jar:       jar,
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			if !followRedirects {
+				return http.ErrUseLastResponse
+			}
+			if len(via) >= 10 {
+				return fmt.Errorf("stopped after %d redirects", len(via))
+			}
+			fmt.Printf("Redirect: %s -> %s\n", req.Response.Status, req.URL)
+			return nil
+		},
+	}
+}
+
+// do sends req through the session's client, dialing through overrides. The caller must close the response body.
+func (s *session) do(req *http.Request, overrides dialOverrides) (*http.Response, error) {
+	client := s.client
+	if req.Context().Value(bareRequestKey{}) != nil {
+		bare := *s.client
+		bare.Jar = nil
+		client = &bare
+	} else {
+		s.addHeaders(req)
+	}
+	ctx := withConnConfig(withDialOverrides(req.Context(), overrides), s.conn)
+	ctx = httptrace.WithClientTrace(ctx, s.informationalTrace())
+	resp, err := client.Do(req.WithContext(ctx))
+	if err != nil {
+		return nil, &toolError{kind: classifyError(err), err: fmt.Errorf("request failed: %w", err)}
+	}
+	return resp, nil
+}
+
+// addHeaders adds the -H and profile headers that req does not set itself.
+func (s *session) addHeaders(req *http.Request) {
+	for name, values := range s.header {
+		if name == "Host" {
+			if req.Host == req.URL.Host {
+				req.Host = values[0]
+			}
+		} else if _, ok := req.Header[name]; !ok {
+			req.Header[name] = values
+		}
+	}
+}
+
+// newRequest builds a request sending body, if it is not nil, and announcing it with Expect: 100-continue when
+// -expect-continue is set.
+func (s *session) newRequest(ctx context.Context, method, urlStr string, body []byte) (*http.Request, error) {
+	var r io.Reader
+	if body != nil {
+		r = bytes.NewReader(body)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, urlStr, r)
+	if err != nil {
+		return nil, newError(errBadInput, "building request failed: %v", err)
+	}
+	if body != nil && s.opts.expectContinue > 0 {
+		req.Header.Set("Expect", "100-continue")
+	}
+	return req, nil
+}
+
+// informationalTrace prints the interim 1xx responses, such as 100 Continue and 103 Early Hints, that the client
+// otherwise handles silently.
+func (s *session) informationalTrace() *httptrace.ClientTrace {
+	var waitStart time.Time
+	return &httptrace.ClientTrace{
+		Wait100Continue: func() {
+			waitStart = time.Now()
+			fmt.Printf("Waiting up to %s for 100 Continue\n", s.opts.expectContinue)
+		},
+		Got1xxResponse: func(code int, header textproto.MIMEHeader) error {
+			fmt.Printf("Informational: %d %s", code, http.StatusText(code))
+			if code == http.StatusContinue && !waitStart.IsZero() {
+				fmt.Printf(" after %s", time.Since(waitStart).Round(time.Microsecond))
+			}
+			fmt.Printf("\n")
+			for _, k := range slices.Sorted(maps.Keys(header)) {
+				for _, v := range header[k] {
+					fmt.Printf("  %s: %s\n", k, v)
+				}
+			}
+			return nil
+		},
+	}
+}
+
+func (s *session) fetch(req *http.Request, overrides dialOverrides) (*http.Response, []byte, error) {
+	resp, err := s.do(req, overrides)
+	if err != nil {
+		return nil, nil, err
 	}
 	defer resp.Body.Close()
-	defer sharedTransport.CloseIdleConnections()
 
 	body, err := io.ReadAll(resp.Body)
 	if err != nil {
-		log.Fatalf("read failed: %v", err)
+		return nil, nil, &toolError{kind: errRead, err: fmt.Errorf("read failed: %w", err)}
 	}
+	return resp, body, nil
+}
 
+func printResponse(resp *http.Response, body []byte) {
 	fmt.Printf("Status: %s\n", resp.Status)
 	fmt.Printf("Protocol: %s\n", resp.Proto)
 	fmt.Printf("Headers:\n")
@@ -94,20 +912,31 @@ func main() {
 		}
 	}
 	fmt.Printf("Body length: %d bytes\n", len(body))
+	// Trailers are only known once the body has been read; ones announced but never sent stay empty.
+	if len(resp.Trailer) > 0 {
+		fmt.Printf("Trailers:\n")
+		for k, vals := range resp.Trailer {
+			if len(vals) == 0 {
+				fmt.Printf("  %s: (announced, not sent)\n", k)
+			}
+			for _, v := range vals {
+				fmt.Printf("  %s: %s\n", k, v)
+			}
+		}
+	}
 }
 
-func pickPort(parsedURL *url.URL) string {
+func pickPort(parsedURL *url.URL) (string, error) {
 	port := parsedURL.Port()
 	if port != "" {
-		return port
+		return port, nil
 	}
 	switch strings.ToLower(parsedURL.Scheme) {
-	case "https":
-		return "443"
-	case "http":
-		return "80"
+	case "https", "wss":
+		return "443", nil
+	case "http", "ws":
+		return "80", nil
 	default:
-		log.Fatalf("unknown url scheme %q", parsedURL.Scheme)
+		return "", newError(errBadInput, "unknown url scheme %q", parsedURL.Scheme)
 	}
-	return ""
 }
@@ -0,0 +1,175 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+)
+
+// runArgs calls run with opts and args as the command line's positional arguments.
+func runArgs(t *testing.T, opts options, args ...string) error {
+	t.Helper()
+	old := flag.CommandLine
+	t.Cleanup(func() { flag.CommandLine = old })
+	flag.CommandLine = flag.NewFlagSet("gotest", flag.ContinueOnError)
+	if err := flag.CommandLine.Parse(args); err != nil {
+		t.Fatal(err)
+	}
+	return run(opts)
+}
+
+func TestRunRejectsAuditWithOtherModes(t *testing.T) {
+	tests := []struct {
+		name string
+		opts options
+		url  string
+		want string
+	}{
+		{name: "download", opts: options{audit: true, output: "out"}, want: "-o"},
+		{name: "cache", opts: options{audit: true, cache: true}, want: "-cache"},
+		{name: "stream", opts: options{audit: true, streamEvents: 3}, want: "-stream"},
+		{name: "cors", opts: options{audit: true, corsOrigin: "https://a.example"}, want: "-cors-origin"},
+		{name: "websocket", opts: options{audit: true}, url: "WSS://example.com/", want: "wss:// url"},
+	}
+	for _, tt := range tests {
+		if tt.url == "" {
+			tt.url = "https://example.com/"
+		}
+		err := runArgs(t, tt.opts, tt.url)
+		if kindOf(err) != errBadInput || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("%s: run() = %v, want bad input naming %s", tt.name, err, tt.want)
+		}
+	}
+}
+
+func TestRunRejectsRequestBodyWithOtherModes(t *testing.T) {
+	data := filepath.Join(t.TempDir(), "body")
+	if err := os.WriteFile(data, []byte("q=1"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name string
+		opts options
+		url  string
+		want string
+	}{
+		{name: "cache", opts: options{method: "POST", cache: true}, want: "-cache"},
+		{name: "cors", opts: options{dataFile: data, corsOrigin: "https://a.example"}, want: "-cors-origin"},
+		{name: "grpc", opts: options{method: "PUT", grpcHealth: true}, want: "-grpc-health"},
+		{name: "expect continue with tls scan", opts: options{dataFile: data, expectContinue: time.Second, tlsScan: true}, want: "-tls-scan"},
+		{name: "websocket", opts: options{method: "POST"}, url: "ws://example.com/", want: "ws:// url"},
+		{name: "resumed POST download", opts: options{dataFile: data, output: "out", resume: true}, want: "need a GET request, not POST"},
+		{name: "POST range download", opts: options{method: "post", output: "out", byteRange: "0-9"}, want: "need a GET request, not POST"},
+	}
+	for _, tt := range tests {
+		if tt.url == "" {
+			tt.url = "https://example.com/"
+		}
+		err := runArgs(t, tt.opts, tt.url)
+		if kindOf(err) != errBadInput || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("%s: run() = %v, want bad input naming %s", tt.name, err, tt.want)
+		}
+	}
+}
+
+func TestPrintResponseInformationalAndTrailers(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Link", "</style.css>; rel=preload")
+		w.WriteHeader(http.StatusEarlyHints)
+		w.Header().Del("Link")
+		w.Header().Set("Trailer", "X-Checksum, X-Missing")
+		io.WriteString(w, "hello")
+		w.Header().Set("X-Checksum", "abc")
+	}))
+	defer srv.Close()
+
+	s, err := newSession(options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.close()
+	req, _ := http.NewRequest(http.MethodGet, srv.URL, nil)
+	out := captureStdout(t, func() {
+		resp, body, err := s.fetch(req, nil)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		printResponse(resp, body)
+	})
+	for _, want := range []string{
+		"Informational: 103 Early Hints\n  Link: </style.css>; rel=preload\n",
+		"Status: 200 OK\n",
+		"Body length: 5 bytes\nTrailers:\n",
+		"  X-Checksum: abc\n",
+		"  X-Missing: (announced, not sent)\n",
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("output lacks %q:\n%s", want, out)
+		}
+	}
+}
+
+func TestExpectContinue(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// Reading the body is what makes the server send 100 Continue.
+		body, _ := io.ReadAll(r.Body)
+		w.Write(body)
+	}))
+	defer srv.Close()
+
+	s, err := newSession(options{expectContinue: 5 * time.Second})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.close()
+	req, err := s.newRequest(context.Background(), http.MethodPost, srv.URL, []byte("payload"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var body []byte
+	out := captureStdout(t, func() { _, body, err = s.fetch(req, nil) })
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(body) != "payload" {
+		t.Errorf("body = %q, want the echoed payload", body)
+	}
+	if !regexp.MustCompile(`Waiting up to 5s for 100 Continue\nInformational: 100 Continue after \S+\n`).MatchString(out) {
+		t.Errorf("output lacks the 100 Continue wait:\n%s", out)
+	}
+}
+
+func TestDialOverridesLookup(t *testing.T) {
+	overrides := dialOverrides{}
+	overrides.set("API.example.com", "10.0.0.1")
+	overrides.set("www.Example.com.:443", "10.0.0.2:8443")
+	overrides.set("[::1]:443", "10.0.0.3")
+	tests := []struct {
+		addr   string
+		want   string
+		wantOK bool
+	}{
+		{addr: "api.example.com:443", want: "10.0.0.1:443", wantOK: true},
+		{addr: "Api.EXAMPLE.com.:8080", want: "10.0.0.1:8080", wantOK: true},
+		{addr: "WWW.example.com:443", want: "10.0.0.2:8443", wantOK: true},
+		{addr: "www.example.com.:443", want: "10.0.0.2:8443", wantOK: true},
+		{addr: "[::1]:443", want: "10.0.0.3:443", wantOK: true},
+		{addr: "www.example.com:80"},
+		{addr: "other.example.com:443"},
+	}
+	for _, tt := range tests {
+		got, ok := overrides.lookup(tt.addr)
+		if got != tt.want || ok != tt.wantOK {
+			t.Errorf("lookup(%q) = %q, %v, want %q, %v", tt.addr, got, ok, tt.want, tt.wantOK)
+		}
+	}
+}
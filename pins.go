@@ -0,0 +1,151 @@
+package main
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+)
+
+const pinFileHeader = "# gotest pins: <host:port> <dial target, or * when dialed by name> sha256/<SPKI digest>"
+
+// Pin modes say what happens when a server presents a key other than the pinned one.
+const (
+	pinWarn   = "warn"
+	pinFail   = "fail"
+	pinUpdate = "update"
+)
+
+// pinKey identifies one backend: the host:port of the URL and the address actually dialed, "*" unless overridden.
+type pinKey struct {
+	addr, target string
+}
+
+// pinStore is a known_hosts style record of the public key each HTTPS backend presented. A key is trusted on first
+// use and later connections are checked against it, which catches a node behind a load balancer that missed a
+// certificate rotation. Overridden dials are pinned per target, so every node behind one name is tracked on its own.
+type pinStore struct {
+	mode string
+
+	mu      sync.Mutex
+	pins    map[pinKey]string
+	changed bool
+}
+
+func newPinStore(mode string) (*pinStore, error) {
+	switch mode {
+	case pinWarn, pinFail, pinUpdate:
+	default:
+		return nil, fmt.Errorf("bad pin mode %q, want %s, %s or %s", mode, pinWarn, pinFail, pinUpdate)
+	}
+	return &pinStore{mode: mode, pins: make(map[pinKey]string)}, nil
+}
+
+// spkiPin returns the pin of cert's public key, in the sha256/<base64> form HPKP used.
+func spkiPin(cert *x509.Certificate) string {
+	sum := sha256.Sum256(cert.RawSubjectPublicKeyInfo)
+	return "sha256/" + base64.StdEncoding.EncodeToString(sum[:])
+}
+
+func (p *pinStore) Load(path string) error {
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	scanner := bufio.NewScanner(f)
+	for lineNo := 1; scanner.Scan(); lineNo++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.Fields(line)
+		if len(fields) != 3 {
+			return fmt.Errorf("%s:%d: expected 3 fields, got %d", path, lineNo, len(fields))
+		}
+		if !strings.HasPrefix(fields[2], "sha256/") {
+			return fmt.Errorf("%s:%d: bad pin %q", path, lineNo, fields[2])
+		}
+		p.pins[pinKey{addr: fields[0], target: fields[1]}] = fields[2]
+	}
+	return scanner.Err()
+}
+
+// Save writes the store back to path, if anything was pinned or replaced since it was loaded.
+func (p *pinStore) Save(path string) error {
+	p.mu.Lock()
+	if !p.changed {
+		p.mu.Unlock()
+		return nil
+	}
+	keys := make([]pinKey, 0, len(p.pins))
+	for k := range p.pins {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(a, b int) bool {
+		if keys[a].addr != keys[b].addr {
+			return keys[a].addr < keys[b].addr
+		}
+		return keys[a].target < keys[b].target
+	})
+
+	var b strings.Builder
+	b.WriteString(pinFileHeader + "\n\n")
+	for _, k := range keys {
+		fmt.Fprintf(&b, "%s %s %s\n", k.addr, k.target, p.pins[k])
+	}
+	p.mu.Unlock()
+	return os.WriteFile(path, []byte(b.String()), 0o600)
+}
+
+// check compares the key of leaf, presented by addr when dialed at target ("" when not overridden), with the pinned
+// one. A target without a pin of its own is compared with the pin of the name, so the first probe of a stale node is
+// already caught. Keys seen for the first time are pinned; a different key is reported, fails the connection or
+// replaces the pin, depending on the mode.
+func (p *pinStore) check(addr, target string, leaf *x509.Certificate) error {
+	key := pinKey{addr: addr, target: target}
+	via := ""
+	if target == "" {
+		key.target = "*"
+	} else {
+		via = " via " + target
+	}
+	got := spkiPin(leaf)
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	want, ok := p.pins[key]
+	source := "pinned"
+	if !ok && key.target != "*" {
+		if want, ok = p.pins[pinKey{addr: addr, target: "*"}]; ok {
+			source = "pinned for " + addr
+		}
+	}
+	switch {
+	case !ok || want == got && p.pins[key] == "":
+		fmt.Printf("Pinned %s%s: %s\n", addr, via, got)
+	case want == got:
+		return nil
+	case p.mode == pinFail:
+		return newError(errTLSPinMismatch, "%s%s presents key %s, %s is %s", addr, via, got, source, want)
+	case p.mode == pinUpdate:
+		fmt.Printf("Re-pinned %s%s: %s, was %s\n", addr, via, got, want)
+	default:
+		fmt.Printf("Warning: %s%s presents key %s, %s is %s\n", addr, via, got, source, want)
+		return nil
+	}
+	p.pins[key] = got
+	p.changed = true
+	return nil
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"crypto/x509"
+	"math/big"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestPinStoreCheck(t *testing.T) {
+	ca := newTestCA(t, "Test CA")
+	oldKey := newTestCert(t, &x509.Certificate{SerialNumber: big.NewInt(1)}, ca).cert
+	newKey := newTestCert(t, &x509.Certificate{SerialNumber: big.NewInt(2)}, ca).cert
+	const addr = "api.example.com:443"
+
+	tests := []struct {
+		name     string
+		mode     string
+		target   string
+		leaf     *x509.Certificate
+		wantErr  bool
+		wantPin  *x509.Certificate // pin stored for the target afterwards, nil for none
+		wantSays string
+	}{
+		{name: "same key", mode: pinFail, leaf: oldKey, wantPin: oldKey},
+		{name: "warn", mode: pinWarn, leaf: newKey, wantPin: oldKey, wantSays: "Warning"},
+		{name: "fail", mode: pinFail, leaf: newKey, wantErr: true, wantPin: oldKey},
+		{name: "update", mode: pinUpdate, leaf: newKey, wantPin: newKey, wantSays: "Re-pinned"},
+		{name: "new target matching the name", mode: pinFail, target: "10.0.0.1:443", leaf: oldKey, wantPin: oldKey, wantSays: "Pinned"},
+		{name: "new target differing from the name", mode: pinFail, target: "10.0.0.2:443", leaf: newKey, wantErr: true},
+	}
+	for _, tt := range tests {
+		p, err := newPinStore(tt.mode)
+		if err != nil {
+			t.Fatal(err)
+		}
+		p.pins[pinKey{addr: addr, target: "*"}] = spkiPin(oldKey)
+		var checkErr error
+		out := captureStdout(t, func() { checkErr = p.check(addr, tt.target, tt.leaf) })
+		if (checkErr != nil) != tt.wantErr {
+			t.Errorf("%s: check() = %v, want error %v", tt.name, checkErr, tt.wantErr)
+		}
+		if tt.wantErr && kindOf(checkErr) != errTLSPinMismatch {
+			t.Errorf("%s: error kind = %v, want a pin mismatch", tt.name, kindOf(checkErr))
+		}
+		target := tt.target
+		if target == "" {
+			target = "*"
+		}
+		want := ""
+		if tt.wantPin != nil {
+			want = spkiPin(tt.wantPin)
+		}
+		if got := p.pins[pinKey{addr: addr, target: target}]; got != want {
+			t.Errorf("%s: pin afterwards = %q, want %q", tt.name, got, want)
+		}
+		if !strings.Contains(out, tt.wantSays) {
+			t.Errorf("%s: printed %q, want %q", tt.name, out, tt.wantSays)
+		}
+	}
+	if _, err := newPinStore("strict"); err == nil {
+		t.Errorf("newPinStore() accepted an unknown mode")
+	}
+}
+
+func TestPinStoreSaveLoad(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "pins")
+	p, _ := newPinStore(pinWarn)
+	p.pins[pinKey{"b.example.com:443", "*"}] = "sha256/b"
+	p.pins[pinKey{"a.example.com:443", "10.0.0.1:443"}] = "sha256/a1"
+	p.pins[pinKey{"a.example.com:443", "*"}] = "sha256/a"
+
+	// Nothing changed since loading, so nothing is written.
+	if err := p.Save(path); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Fatalf("unchanged store was saved: %v", err)
+	}
+	p.changed = true
+	if err := p.Save(path); err != nil {
+		t.Fatal(err)
+	}
+	data, _ := os.ReadFile(path)
+	want := pinFileHeader + "\n\n" +
+		"a.example.com:443 * sha256/a\n" +
+		"a.example.com:443 10.0.0.1:443 sha256/a1\n" +
+		"b.example.com:443 * sha256/b\n"
+	if string(data) != want {
+		t.Errorf("saved:\n%s\nwant:\n%s", data, want)
+	}
+
+	loaded, _ := newPinStore(pinWarn)
+	if err := loaded.Load(path); err != nil {
+		t.Fatal(err)
+	}
+	if len(loaded.pins) != 3 || loaded.pins[pinKey{"a.example.com:443", "10.0.0.1:443"}] != "sha256/a1" {
+		t.Errorf("loaded %v", loaded.pins)
+	}
+
+	for _, bad := range []string{"a.example.com:443 * sha256/a extra\n", "a.example.com:443 * md5/a\n"} {
+		if err := os.WriteFile(path, []byte(bad), 0o600); err != nil {
+			t.Fatal(err)
+		}
+		if err := loaded.Load(path); err == nil {
+			t.Errorf("Load() accepted %q", bad)
+		}
+	}
+}
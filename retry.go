@@ -0,0 +1,187 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// retryPolicy decides which failed attempts are retried and how long to wait in between. Waits grow exponentially
+// from baseBackoff up to maxBackoff, with full jitter so that many clients retrying at once spread out.
+type retryPolicy struct {
+	retries     int
+	baseBackoff time.Duration
+	maxBackoff  time.Duration
+	statuses    map[int]bool
+	errClasses  map[string]bool
+	unsafe      bool // retry non-idempotent requests even when they may have reached the server
+}
+
+// retryErrorClasses are the values accepted by -retry-on.
+var retryErrorClasses = []string{"dns", "refused", "timeout", "reset", "eof", "tls"}
+
+func newRetryPolicy(opts options) (*retryPolicy, error) {
+	p := &retryPolicy{
+		retries:     opts.retries,
+		baseBackoff: opts.retryBackoff,
+		maxBackoff:  opts.retryMaxBackoff,
+		statuses:    make(map[int]bool),
+		errClasses:  make(map[string]bool),
+		unsafe:      opts.retryUnsafe,
+	}
+	for _, s := range splitList(opts.retryStatus) {
+		code, err := strconv.Atoi(s)
+		if err != nil || code < 100 || code > 599 {
+			return nil, fmt.Errorf("bad retry status %q", s)
+		}
+		p.statuses[code] = true
+	}
+	for _, class := range splitList(opts.retryOn) {
+		if !slices.Contains(retryErrorClasses, class) {
+			return nil, fmt.Errorf("unknown retry error class %q, want one of %s", class, strings.Join(retryErrorClasses, ","))
+		}
+		p.errClasses[class] = true
+	}
+	return p, nil
+}
+
+// retryTransport re-sends a request according to its policy. It sits between the client and the rest of the
+// transport chain so that each redirect hop is retried on its own and every attempt shows up in -v and HAR output.
+type retryTransport struct {
+	next   http.RoundTripper
+	policy *retryPolicy
+}
+
+func (t *retryTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	for attempt := 1; ; attempt++ {
+		if attempt > 1 && req.Body != nil && req.Body != http.NoBody {
+			if req.GetBody == nil {
+				return nil, errors.New("cannot retry request: body is not replayable")
+			}
+			body, err := req.GetBody()
+			if err != nil {
+				return nil, err
+			}
+			req = req.Clone(req.Context())
+			req.Body = body
+		}
+
+		resp, err := t.next.RoundTrip(req)
+		reason, retry := t.policy.shouldRetry(req, resp, err)
+		if !retry || attempt > t.policy.retries {
+			if attempt > 1 || reason != "" {
+				fmt.Printf("Attempt %d/%d: %s\n", attempt, t.policy.retries+1, attemptOutcome(resp, err))
+			}
+			return resp, err
+		}
+
+		wait := t.policy.backoff(attempt, resp)
+		fmt.Printf("Attempt %d/%d: %s, retrying in %s\n", attempt, t.policy.retries+1, reason, wait.Round(time.Millisecond))
+		if resp != nil {
+			io.Copy(io.Discard, io.LimitReader(resp.Body, 1<<20))
+			resp.Body.Close()
+		}
+		timer := time.NewTimer(wait)
+		select {
+		case <-req.Context().Done():
+			timer.Stop()
+			return nil, req.Context().Err()
+		case <-timer.C:
+		}
+	}
+}
+
+func attemptOutcome(resp *http.Response, err error) string {
+	if err != nil {
+		return "failed: " + err.Error()
+	}
+	return resp.Status
+}
+
+// shouldRetry reports whether an attempt failed in a retryable way, and a description of the failure if it failed
+// at all.
+func (p *retryPolicy) shouldRetry(req *http.Request, resp *http.Response, err error) (string, bool) {
+	if err != nil {
+		class := classifyRetryError(err)
+		reason := fmt.Sprintf("failed (%s): %v", class, err)
+		if !p.errClasses[class] {
+			return reason, false
+		}
+		// Errors from before the connection was up mean the request was never sent, so it is safe to send it again
+		// whatever its method.
+		if class == "dns" || class == "refused" {
+			return reason, true
+		}
+		return reason, p.replayable(req)
+	}
+	if p.statuses[resp.StatusCode] {
+		return resp.Status, p.replayable(req)
+	}
+	return "", false
+}
+
+// replayable reports whether the request may be sent again after the server might have acted on it: for idempotent
+// methods (RFC 9110 section 9.2.2), requests carrying an Idempotency-Key, and when -retry-unsafe says so.
+func (p *retryPolicy) replayable(req *http.Request) bool {
+	switch req.Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodTrace, http.MethodPut, http.MethodDelete:
+		return true
+	}
+	return p.unsafe || req.Header.Get("Idempotency-Key") != ""
+}
+
+// backoff returns the wait before the next attempt. A Retry-After in seconds from the server takes precedence, capped
+// at the maximum backoff.
+func (p *retryPolicy) backoff(attempt int, resp *http.Response) time.Duration {
+	if resp != nil {
+		if secs, err := strconv.Atoi(resp.Header.Get("Retry-After")); err == nil && secs >= 0 {
+			return min(time.Duration(secs)*time.Second, p.maxBackoff)
+		}
+	}
+	// Compare against the maximum shifted right so that large attempt counts cannot overflow the shift.
+	ceiling := p.maxBackoff
+	if shift := attempt - 1; shift < 63 && p.baseBackoff <= p.maxBackoff>>shift {
+		ceiling = p.baseBackoff << shift
+	}
+	if ceiling <= 0 {
+		return 0
+	}
+	return time.Duration(rand.Int63n(int64(ceiling) + 1))
+}
+
+func classifyRetryError(err error) string {
+	switch {
+	case errors.Is(err, syscall.ECONNRESET), errors.Is(err, syscall.EPIPE):
+		return "reset"
+	case errors.Is(err, io.EOF), errors.Is(err, io.ErrUnexpectedEOF):
+		return "eof"
+	}
+	switch classifyError(err) {
+	case errDNS:
+		return "dns"
+	case errRefused:
+		return "refused"
+	case errDialTimeout, errTimeout:
+		return "timeout"
+	case errTLSHostname, errTLSUnknownCA, errTLSExpired, errTLS:
+		return "tls"
+	}
+	return "other"
+}
+
+func splitList(s string) []string {
+	var out []string
+	for _, part := range strings.Split(s, ",") {
+		if part = strings.TrimSpace(part); part != "" {
+			out = append(out, part)
+		}
+	}
+	return out
+}
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestShouldRetry(t *testing.T) {
+	policy, err := newRetryPolicy(options{retries: 1, retryStatus: "503", retryOn: "dns,refused,reset"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	dnsErr := &net.DNSError{Err: "no such host", Name: "missing.example.com", IsNotFound: true}
+	refusedErr := &net.OpError{Op: "dial", Net: "tcp", Err: os.NewSyscallError("connect", syscall.ECONNREFUSED)}
+	resetErr := &net.OpError{Op: "read", Net: "tcp", Err: os.NewSyscallError("read", syscall.ECONNRESET)}
+	unavailable := &http.Response{Status: "503 Service Unavailable", StatusCode: http.StatusServiceUnavailable}
+	notFound := &http.Response{Status: "404 Not Found", StatusCode: http.StatusNotFound}
+
+	tests := []struct {
+		name       string
+		method     string
+		key        bool // send an Idempotency-Key
+		unsafe     bool
+		resp       *http.Response
+		err        error
+		want       bool
+		wantReason string
+	}{
+		{name: "GET status", method: http.MethodGet, resp: unavailable, want: true, wantReason: "503"},
+		{name: "PUT status", method: http.MethodPut, resp: unavailable, want: true},
+		{name: "POST status", method: http.MethodPost, resp: unavailable, wantReason: "503"},
+		{name: "POST status with key", method: http.MethodPost, key: true, resp: unavailable, want: true},
+		{name: "POST status unsafe", method: http.MethodPost, unsafe: true, resp: unavailable, want: true},
+		{name: "PATCH status", method: http.MethodPatch, resp: unavailable},
+		{name: "other status", method: http.MethodGet, resp: notFound},
+		{name: "GET reset", method: http.MethodGet, err: resetErr, want: true, wantReason: "failed (reset)"},
+		{name: "POST reset", method: http.MethodPost, err: resetErr, wantReason: "failed (reset)"},
+		{name: "POST dns", method: http.MethodPost, err: dnsErr, want: true, wantReason: "failed (dns)"},
+		{name: "POST refused", method: http.MethodPost, err: refusedErr, want: true, wantReason: "failed (refused)"},
+		{name: "class not enabled", method: http.MethodGet, err: io.ErrUnexpectedEOF, wantReason: "failed (eof)"},
+	}
+	for _, tt := range tests {
+		req, _ := http.NewRequest(tt.method, "https://example.com/", nil)
+		if tt.key {
+			req.Header.Set("Idempotency-Key", "abc")
+		}
+		policy.unsafe = tt.unsafe
+		reason, got := policy.shouldRetry(req, tt.resp, tt.err)
+		if got != tt.want {
+			t.Errorf("%s: shouldRetry() = %v, want %v", tt.name, got, tt.want)
+		}
+		if !strings.Contains(reason, tt.wantReason) {
+			t.Errorf("%s: reason = %q, want it to contain %q", tt.name, reason, tt.wantReason)
+		}
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	withRetryAfter := func(v string) *http.Response {
+		return &http.Response{Header: http.Header{"Retry-After": {v}}}
+	}
+	tests := []struct {
+		name    string
+		base    time.Duration
+		attempt int
+		resp    *http.Response
+		want    time.Duration // upper bound of the jittered wait, or the exact wait when exact is set
+		exact   bool
+	}{
+		{name: "first attempt", base: 100 * time.Millisecond, attempt: 1, want: 100 * time.Millisecond},
+		{name: "doubles", base: 100 * time.Millisecond, attempt: 3, want: 400 * time.Millisecond},
+		{name: "capped", base: 100 * time.Millisecond, attempt: 10, want: time.Second},
+		{name: "large attempt", base: 100 * time.Millisecond, attempt: 1000, want: time.Second},
+		{name: "zero base", base: 0, attempt: 5, want: 0, exact: true},
+		{name: "retry after", base: 100 * time.Millisecond, attempt: 1, resp: withRetryAfter("0"), want: 0, exact: true},
+		{name: "retry after capped", base: 100 * time.Millisecond, attempt: 1, resp: withRetryAfter("120"), want: time.Second, exact: true},
+		{name: "retry after date ignored", base: 100 * time.Millisecond, attempt: 1, resp: withRetryAfter("Wed, 21 Oct 2015 07:28:00 GMT"), want: 100 * time.Millisecond},
+	}
+	for _, tt := range tests {
+		p := &retryPolicy{baseBackoff: tt.base, maxBackoff: time.Second}
+		for range 50 {
+			got := p.backoff(tt.attempt, tt.resp)
+			if tt.exact && got != tt.want || got < 0 || got > tt.want {
+				t.Errorf("%s: backoff() = %v, want %v (exact %v)", tt.name, got, tt.want, tt.exact)
+				break
+			}
+		}
+	}
+}
+
+func TestRetryTransport(t *testing.T) {
+	var bodies []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, _ := io.ReadAll(r.Body)
+		bodies = append(bodies, string(b))
+		if len(bodies) < 3 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		fmt.Fprint(w, "ok")
+	}))
+	defer srv.Close()
+
+	policy, err := newRetryPolicy(options{retries: 3, retryStatus: "503", retryUnsafe: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	rt := &retryTransport{next: srv.Client().Transport, policy: policy}
+	req, _ := http.NewRequest(http.MethodPost, srv.URL, strings.NewReader("payload"))
+	var resp *http.Response
+	out := captureStdout(t, func() { resp, err = rt.RoundTrip(req) })
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("status = %d, want 200", resp.StatusCode)
+	}
+	if len(bodies) != 3 {
+		t.Fatalf("server saw %d attempts, want 3", len(bodies))
+	}
+	for i, b := range bodies {
+		if b != "payload" {
+			t.Errorf("attempt %d sent body %q, want the replayed payload", i+1, b)
+		}
+	}
+	for _, want := range []string{"Attempt 1/4: 503 Service Unavailable, retrying", "Attempt 2/4: 503", "Attempt 3/4: 200 OK"} {
+		if !strings.Contains(out, want) {
+			t.Errorf("output lacks %q:\n%s", want, out)
+		}
+	}
+
+	// A body without GetBody cannot be sent twice.
+	bodies = nil
+	req, _ = http.NewRequest(http.MethodPost, srv.URL, io.NopCloser(strings.NewReader("payload")))
+	captureStdout(t, func() { _, err = rt.RoundTrip(req) })
+	if err == nil || len(bodies) != 1 {
+		t.Errorf("RoundTrip() with an unreplayable body = %v after %d attempts, want an error after 1", err, len(bodies))
+	}
+}
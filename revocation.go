@@ -0,0 +1,403 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha1"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"net"
+	"net/http"
+	"time"
+)
+
+var (
+	oidSHA1             = asn1.ObjectIdentifier{1, 3, 14, 3, 2, 26}
+	oidOCSPBasic        = asn1.ObjectIdentifier{1, 3, 6, 1, 5, 5, 7, 48, 1, 1}
+	signatureAlgorithms = map[string]x509.SignatureAlgorithm{
+		"1.2.840.113549.1.1.5":  x509.SHA1WithRSA,
+		"1.2.840.113549.1.1.11": x509.SHA256WithRSA,
+		"1.2.840.113549.1.1.12": x509.SHA384WithRSA,
+		"1.2.840.113549.1.1.13": x509.SHA512WithRSA,
+		"1.2.840.10045.4.1":     x509.ECDSAWithSHA1,
+		"1.2.840.10045.4.3.2":   x509.ECDSAWithSHA256,
+		"1.2.840.10045.4.3.3":   x509.ECDSAWithSHA384,
+		"1.2.840.10045.4.3.4":   x509.ECDSAWithSHA512,
+		"1.3.101.112":           x509.PureEd25519,
+	}
+)
+
+// The OCSP structures of RFC 6960, as much of them as a client needs.
+type ocspCertID struct {
+	HashAlgorithm pkix.AlgorithmIdentifier
+	NameHash      []byte
+	KeyHash       []byte
+	SerialNumber  *big.Int
+}
+
+type ocspRequest struct {
+	TBSRequest struct {
+		RequestList []struct {
+			Cert ocspCertID
+		}
+	}
+}
+
+type ocspResponse struct {
+	Status   asn1.Enumerated
+	Response struct {
+		Type     asn1.ObjectIdentifier
+		Response []byte
+	} `asn1:"explicit,tag:0,optional"`
+}
+
+type ocspBasicResponse struct {
+	TBSResponseData    asn1.RawValue
+	SignatureAlgorithm pkix.AlgorithmIdentifier
+	Signature          asn1.BitString
+	Certificates       []asn1.RawValue `asn1:"explicit,tag:0,optional"`
+}
+
+type ocspResponseData struct {
+	Version     int `asn1:"optional,default:0,explicit,tag:0"`
+	ResponderID asn1.RawValue
+	ProducedAt  time.Time `asn1:"generalized"`
+	Responses   []ocspSingleResponse
+	Extensions  []pkix.Extension `asn1:"explicit,tag:1,optional"`
+}
+
+type ocspSingleResponse struct {
+	CertID     ocspCertID
+	Status     asn1.RawValue
+	ThisUpdate time.Time        `asn1:"generalized"`
+	NextUpdate time.Time        `asn1:"generalized,explicit,tag:0,optional"`
+	Extensions []pkix.Extension `asn1:"explicit,tag:1,optional"`
+}
+
+type ocspRevokedInfo struct {
+	RevocationTime time.Time       `asn1:"generalized"`
+	Reason         asn1.Enumerated `asn1:"explicit,tag:0,optional"`
+}
+
+// ocspStatus is what an OCSP response says about one certificate.
+type ocspStatus struct {
+	status     string // good, revoked or unknown
+	revokedAt  time.Time
+	producedAt time.Time
+	nextUpdate time.Time
+	scts       [][]byte
+}
+
+func (st ocspStatus) String() string {
+	s := st.status
+	if st.status == "revoked" {
+		s += " at " + st.revokedAt.UTC().Format(time.RFC3339)
+	}
+	s += ", produced " + st.producedAt.UTC().Format(time.RFC3339)
+	if !st.nextUpdate.IsZero() {
+		s += ", next update " + st.nextUpdate.UTC().Format(time.RFC3339)
+	}
+	return s
+}
+
+// stale reports whether the response is past its next update.
+func (st ocspStatus) stale() bool {
+	return !st.nextUpdate.IsZero() && st.nextUpdate.Before(time.Now())
+}
+
+// checkCurrent rejects a stale response, which says nothing about the certificate's status now.
+func (st ocspStatus) checkCurrent() error {
+	if st.stale() {
+		return fmt.Errorf("OCSP response expired at %s", st.nextUpdate)
+	}
+	return nil
+}
+
+func newOCSPCertID(leaf, issuer *x509.Certificate) (ocspCertID, error) {
+	var spki struct {
+		Algorithm pkix.AlgorithmIdentifier
+		PublicKey asn1.BitString
+	}
+	if _, err := asn1.Unmarshal(issuer.RawSubjectPublicKeyInfo, &spki); err != nil {
+		return ocspCertID{}, fmt.Errorf("parsing issuer public key: %w", err)
+	}
+	nameHash := sha1.Sum(issuer.RawSubject)
+	keyHash := sha1.Sum(spki.PublicKey.RightAlign())
+	return ocspCertID{
+		HashAlgorithm: pkix.AlgorithmIdentifier{Algorithm: oidSHA1, Parameters: asn1.NullRawValue},
+		NameHash:      nameHash[:],
+		KeyHash:       keyHash[:],
+		SerialNumber:  leaf.SerialNumber,
+	}, nil
+}
+
+func marshalOCSPRequest(id ocspCertID) ([]byte, error) {
+	var req ocspRequest
+	req.TBSRequest.RequestList = append(req.TBSRequest.RequestList, struct{ Cert ocspCertID }{id})
+	return asn1.Marshal(req)
+}
+
+// parseOCSPResponse checks that der is a successful, correctly signed OCSP response about id, issued by issuer or
+// a responder it delegated to. A response past its next update is returned as is, for the caller to judge with
+// checkCurrent.
+func parseOCSPResponse(der []byte, id ocspCertID, issuer *x509.Certificate) (ocspStatus, error) {
+	var resp ocspResponse
+	if _, err := asn1.Unmarshal(der, &resp); err != nil {
+		return ocspStatus{}, fmt.Errorf("malformed OCSP response: %w", err)
+	}
+	if resp.Status != 0 {
+		// tryLater, unauthorized and the like.
+		return ocspStatus{}, fmt.Errorf("OCSP responder returned error status %d", resp.Status)
+	}
+	if !resp.Response.Type.Equal(oidOCSPBasic) {
+		return ocspStatus{}, fmt.Errorf("unsupported OCSP response type %v", resp.Response.Type)
+	}
+	var basic ocspBasicResponse
+	if _, err := asn1.Unmarshal(resp.Response.Response, &basic); err != nil {
+		return ocspStatus{}, fmt.Errorf("malformed basic OCSP response: %w", err)
+	}
+	var data ocspResponseData
+	if _, err := asn1.Unmarshal(basic.TBSResponseData.FullBytes, &data); err != nil {
+		return ocspStatus{}, fmt.Errorf("malformed OCSP response data: %w", err)
+	}
+
+	signer := issuer
+	if len(basic.Certificates) > 0 {
+		responder, err := x509.ParseCertificate(basic.Certificates[0].FullBytes)
+		if err != nil {
+			return ocspStatus{}, fmt.Errorf("parsing OCSP responder certificate: %w", err)
+		}
+		if !bytes.Equal(responder.Raw, issuer.Raw) {
+			if err := responder.CheckSignatureFrom(issuer); err != nil {
+				return ocspStatus{}, fmt.Errorf("OCSP responder certificate not issued by the CA: %w", err)
+			}
+			delegated := false
+			for _, eku := range responder.ExtKeyUsage {
+				delegated = delegated || eku == x509.ExtKeyUsageOCSPSigning
+			}
+			if !delegated {
+				return ocspStatus{}, errors.New("OCSP responder certificate lacks the OCSP signing extended key usage")
+			}
+			signer = responder
+		}
+	}
+	algo, ok := signatureAlgorithms[basic.SignatureAlgorithm.Algorithm.String()]
+	if !ok {
+		return ocspStatus{}, fmt.Errorf("unsupported OCSP signature algorithm %v", basic.SignatureAlgorithm.Algorithm)
+	}
+	if err := signer.CheckSignature(algo, basic.TBSResponseData.FullBytes, basic.Signature.RightAlign()); err != nil {
+		return ocspStatus{}, fmt.Errorf("bad OCSP response signature: %w", err)
+	}
+
+	for _, single := range data.Responses {
+		if single.CertID.SerialNumber.Cmp(id.SerialNumber) != 0 || !bytes.Equal(single.CertID.KeyHash, id.KeyHash) {
+			continue
+		}
+		if single.ThisUpdate.After(time.Now().Add(5 * time.Minute)) {
+			return ocspStatus{}, fmt.Errorf("OCSP response is from the future (%s)", single.ThisUpdate)
+		}
+		st := ocspStatus{producedAt: data.ProducedAt, nextUpdate: single.NextUpdate}
+		switch single.Status.Tag {
+		case 0:
+			st.status = "good"
+		case 1:
+			st.status = "revoked"
+			var info ocspRevokedInfo
+			if _, err := asn1.UnmarshalWithParams(single.Status.FullBytes, &info, "tag:1"); err == nil {
+				st.revokedAt = info.RevocationTime
+			}
+		default:
+			st.status = "unknown"
+		}
+		for _, ext := range single.Extensions {
+			if ext.Id.Equal(oidOCSPSCTList) {
+				st.scts, _ = parseSCTListExtension(ext.Value)
+			}
+		}
+		return st, nil
+	}
+	return ocspStatus{}, errors.New("OCSP response does not cover the certificate")
+}
+
+// checkCRL downloads the CRL at crlURL, checks it was signed by issuer and is current, and reports whether leaf is on
+// it.
+func (s *session) checkCRL(crlURL string, overrides dialOverrides, leaf, issuer *x509.Certificate) (bool, error) {
+	req, err := http.NewRequestWithContext(withBareRequest(context.Background()), http.MethodGet, crlURL, nil)
+	if err != nil {
+		return false, newError(errBadInput, "building request failed: %v", err)
+	}
+	resp, body, err := s.fetch(req, overrides)
+	if err != nil {
+		return false, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return false, fmt.Errorf("fetching CRL: %s", resp.Status)
+	}
+	if block, _ := pem.Decode(body); block != nil {
+		body = block.Bytes
+	}
+	crl, err := x509.ParseRevocationList(body)
+	if err != nil {
+		return false, fmt.Errorf("parsing CRL: %w", err)
+	}
+	if err := crl.CheckSignatureFrom(issuer); err != nil {
+		return false, fmt.Errorf("CRL not signed by the issuer: %w", err)
+	}
+	if !crl.NextUpdate.IsZero() && crl.NextUpdate.Before(time.Now()) {
+		return false, fmt.Errorf("CRL expired at %s", crl.NextUpdate)
+	}
+	fmt.Printf("  %d entries, this update %s", len(crl.RevokedCertificateEntries), crl.ThisUpdate.UTC().Format(time.RFC3339))
+	if !crl.NextUpdate.IsZero() {
+		fmt.Printf(", next update %s", crl.NextUpdate.UTC().Format(time.RFC3339))
+	}
+	fmt.Printf("\n")
+	for _, entry := range crl.RevokedCertificateEntries {
+		if entry.SerialNumber.Cmp(leaf.SerialNumber) == 0 {
+			fmt.Printf("  revoked at %s\n", entry.RevocationTime.UTC().Format(time.RFC3339))
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// checkRevocation connects to host:port like a request would, with the certificate validated against the URL
+// host, and then checks whether the server's certificate has been revoked, using the stapled OCSP response, the OCSP
+// responder and the CRL, and whether it carries enough Certificate Transparency SCTs. ocspURL and crlURL replace the
+// locations named in the certificate when set.
+func (s *session) checkRevocation(host, port string, overrides dialOverrides, target, ocspURL, crlURL string) error {
+	addr := net.JoinHostPort(host, port)
+	fmt.Printf("Revocation check of %s", addr)
+	if target != "" {
+		fmt.Printf(" via %s", target)
+	}
+	fmt.Printf("\n")
+
+	ctx := withConnConfig(withDialOverrides(context.Background(), overrides), s.conn)
+	conn, err := dialOverridden(ctx, "tcp", addr)
+	if err != nil {
+		return &toolError{kind: classifyError(err), err: fmt.Errorf("connecting failed: %w", err)}
+	}
+	cfg := sharedTLSConfig.Clone()
+	cfg.ServerName = host
+	tc := tls.Client(conn, cfg)
+	hsCtx, cancel := context.WithTimeout(ctx, tlsScanHandshakeTimeout)
+	defer cancel()
+	if err := tc.HandshakeContext(hsCtx); err != nil {
+		conn.Close()
+		return &toolError{kind: classifyError(err), err: fmt.Errorf("TLS handshake failed: %w", err)}
+	}
+	state := tc.ConnectionState()
+	tc.Close()
+
+	chain := state.VerifiedChains[0]
+	leaf := chain[0]
+	fmt.Printf("Certificate: %s, serial %s\n", leaf.Subject, leaf.SerialNumber.Text(16))
+	fmt.Printf("  issuer: %s\n", leaf.Issuer)
+	if len(chain) < 2 {
+		return newError(errCheckFailed, "certificate is its own trust anchor, there is no issuer to ask about revocation")
+	}
+	issuer := chain[1]
+
+	id, err := newOCSPCertID(leaf, issuer)
+	if err != nil {
+		return err
+	}
+	// Like browsers, treat an unreachable source as no answer rather than as a failure, and only fail when no
+	// source answered at all.
+	var revoked, answered bool
+	var ocspSCTs [][]byte
+	if len(state.OCSPResponse) > 0 {
+		st, err := parseOCSPResponse(state.OCSPResponse, id, issuer)
+		if err == nil {
+			err = st.checkCurrent()
+		}
+		if err != nil {
+			fmt.Printf("Stapled OCSP: invalid: %v\n", err)
+		} else {
+			fmt.Printf("Stapled OCSP: %s\n", st)
+			revoked = revoked || st.status == "revoked"
+			answered = answered || st.status != "unknown"
+			ocspSCTs = st.scts
+		}
+	} else {
+		fmt.Printf("Stapled OCSP: none\n")
+	}
+
+	if ocspURL == "" && len(leaf.OCSPServer) > 0 {
+		ocspURL = leaf.OCSPServer[0]
+	}
+	if ocspURL == "" {
+		fmt.Printf("OCSP responder: none in certificate\n")
+	} else {
+		st, err := s.queryOCSP(ocspURL, overrides, id, issuer)
+		if err != nil {
+			fmt.Printf("OCSP responder %s: failed: %v\n", ocspURL, err)
+		} else {
+			fmt.Printf("OCSP responder %s: %s\n", ocspURL, st)
+			revoked = revoked || st.status == "revoked"
+			answered = answered || st.status != "unknown"
+		}
+	}
+
+	if crlURL == "" && len(leaf.CRLDistributionPoints) > 0 {
+		crlURL = leaf.CRLDistributionPoints[0]
+	}
+	if crlURL == "" {
+		fmt.Printf("CRL: none in certificate\n")
+	} else {
+		fmt.Printf("CRL %s:\n", crlURL)
+		onCRL, err := s.checkCRL(crlURL, overrides, leaf, issuer)
+		switch {
+		case err != nil:
+			fmt.Printf("  failed: %v\n", err)
+		case !onCRL:
+			fmt.Printf("  not revoked\n")
+		}
+		revoked = revoked || onCRL
+		answered = answered || err == nil
+	}
+
+	reportSCTs(leaf, state.SignedCertificateTimestamps, ocspSCTs)
+
+	if revoked {
+		return newError(errTLSRevoked, "certificate %s is revoked", leaf.SerialNumber.Text(16))
+	}
+	if !answered {
+		return newError(errCheckFailed, "revocation status of certificate %s could not be determined", leaf.SerialNumber.Text(16))
+	}
+	return nil
+}
+
+func (s *session) queryOCSP(ocspURL string, overrides dialOverrides, id ocspCertID, issuer *x509.Certificate) (ocspStatus, error) {
+	der, err := marshalOCSPRequest(id)
+	if err != nil {
+		return ocspStatus{}, err
+	}
+	req, err := http.NewRequestWithContext(withBareRequest(context.Background()), http.MethodPost, ocspURL, bytes.NewReader(der))
+	if err != nil {
+		return ocspStatus{}, newError(errBadInput, "building request failed: %v", err)
+	}
+	req.Header.Set("Content-Type", "application/ocsp-request")
+	req.Header.Set("Accept", "application/ocsp-response")
+	resp, body, err := s.fetch(req, overrides)
+	if err != nil {
+		return ocspStatus{}, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return ocspStatus{}, fmt.Errorf("%s", resp.Status)
+	}
+	st, err := parseOCSPResponse(body, id, issuer)
+	if err == nil {
+		err = st.checkCurrent()
+	}
+	if err != nil {
+		return ocspStatus{}, err
+	}
+	return st, nil
+}
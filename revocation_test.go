@@ -0,0 +1,330 @@
+package main
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"io"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+var oidECDSAWithSHA256 = asn1.ObjectIdentifier{1, 2, 840, 10045, 4, 3, 2}
+
+// testCert is a certificate with its key, for building test PKIs.
+type testCert struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+}
+
+// newTestCert issues a certificate from tmpl, signed by parent or self-signed when parent is nil.
+func newTestCert(t *testing.T, tmpl *x509.Certificate, parent *testCert) *testCert {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tmpl.NotBefore.IsZero() {
+		tmpl.NotBefore = time.Now().Add(-time.Hour)
+		tmpl.NotAfter = time.Now().Add(24 * time.Hour)
+	}
+	signer, signerCert := key, tmpl
+	if parent != nil {
+		signer, signerCert = parent.key, parent.cert
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, signerCert, &key.PublicKey, signer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &testCert{cert: cert, key: key}
+}
+
+func newTestCA(t *testing.T, name string) *testCert {
+	return newTestCert(t, &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: name},
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign | x509.KeyUsageDigitalSignature,
+	}, nil)
+}
+
+// ocspAnswer is what a test OCSP response says, and who signs it.
+type ocspAnswer struct {
+	status     int // tag of the CertStatus choice: 0 good, 1 revoked, 2 unknown
+	revokedAt  time.Time
+	thisUpdate time.Time
+	nextUpdate time.Time
+	signer     *testCert
+	include    *x509.Certificate // certificate sent along with the response
+	scts       [][]byte
+}
+
+// marshalOCSPResponse builds a signed basic OCSP response for id.
+func marshalOCSPResponse(t *testing.T, id ocspCertID, a ocspAnswer) []byte {
+	t.Helper()
+	status := asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: a.status}
+	if a.status == 1 {
+		revokedAt, err := asn1.MarshalWithParams(a.revokedAt.UTC(), "generalized")
+		if err != nil {
+			t.Fatal(err)
+		}
+		status.IsCompound, status.Bytes = true, revokedAt
+	}
+	single := ocspSingleResponse{CertID: id, Status: status, ThisUpdate: time.Now().Add(-time.Hour).UTC()}
+	if !a.thisUpdate.IsZero() {
+		single.ThisUpdate = a.thisUpdate.UTC()
+	}
+	// Left as the zero time, nextUpdate is omitted.
+	if !a.nextUpdate.IsZero() {
+		single.NextUpdate = a.nextUpdate.UTC()
+	}
+	if a.scts != nil {
+		value, err := asn1.Marshal(sctList(a.scts...))
+		if err != nil {
+			t.Fatal(err)
+		}
+		single.Extensions = []pkix.Extension{{Id: oidOCSPSCTList, Value: value}}
+	}
+	keyHash := sha256.Sum256(a.signer.cert.RawSubjectPublicKeyInfo)
+	responderID, err := asn1.Marshal(keyHash[:20])
+	if err != nil {
+		t.Fatal(err)
+	}
+	tbs, err := asn1.Marshal(ocspResponseData{
+		ResponderID: asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 2, IsCompound: true, Bytes: responderID},
+		ProducedAt:  time.Now().UTC().Truncate(time.Second),
+		Responses:   []ocspSingleResponse{single},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	digest := sha256.Sum256(tbs)
+	sig, err := a.signer.key.Sign(rand.Reader, digest[:], crypto.SHA256)
+	if err != nil {
+		t.Fatal(err)
+	}
+	basic := ocspBasicResponse{
+		TBSResponseData:    asn1.RawValue{FullBytes: tbs},
+		SignatureAlgorithm: pkix.AlgorithmIdentifier{Algorithm: oidECDSAWithSHA256},
+		Signature:          asn1.BitString{Bytes: sig, BitLength: 8 * len(sig)},
+	}
+	if a.include != nil {
+		basic.Certificates = []asn1.RawValue{{FullBytes: a.include.Raw}}
+	}
+	basicDER, err := asn1.Marshal(basic)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var resp ocspResponse
+	resp.Response.Type = oidOCSPBasic
+	resp.Response.Response = basicDER
+	der, err := asn1.Marshal(resp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return der
+}
+
+// newOCSPResponder serves answer for whatever certificate it is asked about, checking that requests are well
+// formed.
+func newOCSPResponder(t *testing.T, answer ocspAnswer) *httptest.Server {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		var req ocspRequest
+		if r.Header.Get("Content-Type") != "application/ocsp-request" {
+			http.Error(w, "bad content type", http.StatusBadRequest)
+			return
+		}
+		if _, err := asn1.Unmarshal(body, &req); err != nil || len(req.TBSRequest.RequestList) != 1 {
+			http.Error(w, "bad request", http.StatusBadRequest)
+			return
+		}
+		w.Header().Set("Content-Type", "application/ocsp-response")
+		w.Write(marshalOCSPResponse(t, req.TBSRequest.RequestList[0].Cert, answer))
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func TestQueryOCSP(t *testing.T) {
+	ca := newTestCA(t, "Test CA")
+	otherCA := newTestCA(t, "Other CA")
+	leaf := newTestCert(t, &x509.Certificate{SerialNumber: big.NewInt(1000), Subject: pkix.Name{CommonName: "leaf"}}, ca)
+	delegate := newTestCert(t, &x509.Certificate{
+		SerialNumber: big.NewInt(2000),
+		Subject:      pkix.Name{CommonName: "OCSP responder"},
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageOCSPSigning},
+	}, ca)
+	notDelegate := newTestCert(t, &x509.Certificate{SerialNumber: big.NewInt(2001), Subject: pkix.Name{CommonName: "not a responder"}}, ca)
+	foreignDelegate := newTestCert(t, &x509.Certificate{
+		SerialNumber: big.NewInt(2002),
+		Subject:      pkix.Name{CommonName: "foreign responder"},
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageOCSPSigning},
+	}, otherCA)
+	revokedAt := time.Now().Add(-48 * time.Hour).UTC().Truncate(time.Second)
+	sct := testSCT(1, time.Now())
+
+	tests := []struct {
+		name       string
+		answer     ocspAnswer
+		wantStatus string
+		wantErr    string
+	}{
+		{name: "good", answer: ocspAnswer{status: 0, signer: ca, nextUpdate: time.Now().Add(time.Hour), scts: [][]byte{sct}}, wantStatus: "good"},
+		{name: "revoked", answer: ocspAnswer{status: 1, revokedAt: revokedAt, signer: ca}, wantStatus: "revoked"},
+		{name: "unknown", answer: ocspAnswer{status: 2, signer: ca}, wantStatus: "unknown"},
+		{name: "delegated responder", answer: ocspAnswer{status: 0, signer: delegate, include: delegate.cert}, wantStatus: "good"},
+		{name: "responder without OCSP signing", answer: ocspAnswer{status: 0, signer: notDelegate, include: notDelegate.cert}, wantErr: "OCSP signing"},
+		{name: "responder of another CA", answer: ocspAnswer{status: 0, signer: foreignDelegate, include: foreignDelegate.cert}, wantErr: "not issued by the CA"},
+		{name: "bad signature", answer: ocspAnswer{status: 0, signer: otherCA}, wantErr: "bad OCSP response signature"},
+		{name: "expired", answer: ocspAnswer{status: 0, signer: ca, thisUpdate: time.Now().Add(-48 * time.Hour), nextUpdate: time.Now().Add(-time.Hour)}, wantErr: "expired"},
+		{name: "from the future", answer: ocspAnswer{status: 0, signer: ca, thisUpdate: time.Now().Add(time.Hour)}, wantErr: "future"},
+	}
+	s, err := newSession(options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.close()
+	id, err := newOCSPCertID(leaf.cert, ca.cert)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := newOCSPResponder(t, tt.answer)
+			st, err := s.queryOCSP(srv.URL, nil, id, ca.cert)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("queryOCSP() = %v, %v, want an error about %q", st, err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if st.status != tt.wantStatus {
+				t.Errorf("status = %s, want %s", st.status, tt.wantStatus)
+			}
+			if tt.wantStatus == "revoked" && !st.revokedAt.Equal(revokedAt) {
+				t.Errorf("revoked at %s, want %s", st.revokedAt, revokedAt)
+			}
+			if len(st.scts) != len(tt.answer.scts) {
+				t.Errorf("got %d SCTs, want %d", len(st.scts), len(tt.answer.scts))
+			}
+		})
+	}
+}
+
+func TestParseOCSPResponseOtherCertificate(t *testing.T) {
+	ca := newTestCA(t, "Test CA")
+	leaf := newTestCert(t, &x509.Certificate{SerialNumber: big.NewInt(1000)}, ca)
+	other := newTestCert(t, &x509.Certificate{SerialNumber: big.NewInt(1001)}, ca)
+	id, _ := newOCSPCertID(leaf.cert, ca.cert)
+	otherID, _ := newOCSPCertID(other.cert, ca.cert)
+	der := marshalOCSPResponse(t, otherID, ocspAnswer{status: 0, signer: ca})
+	if _, err := parseOCSPResponse(der, id, ca.cert); err == nil || !strings.Contains(err.Error(), "does not cover") {
+		t.Errorf("parseOCSPResponse() for another certificate = %v", err)
+	}
+	// tryLater, without a response body.
+	if _, err := parseOCSPResponse([]byte{0x30, 0x03, 0x0a, 0x01, 0x03}, id, ca.cert); err == nil || !strings.Contains(err.Error(), "error status 3") {
+		t.Errorf("parseOCSPResponse() of tryLater = %v", err)
+	}
+}
+
+func TestCheckCRL(t *testing.T) {
+	ca := newTestCA(t, "Test CA")
+	otherCA := newTestCA(t, "Other CA")
+	leaf := newTestCert(t, &x509.Certificate{SerialNumber: big.NewInt(1000)}, ca)
+	crl := func(signer *testCert, serials []int64, nextUpdate time.Time) []byte {
+		tmpl := &x509.RevocationList{Number: big.NewInt(1), ThisUpdate: time.Now().Add(-2 * time.Hour), NextUpdate: nextUpdate}
+		for _, serial := range serials {
+			tmpl.RevokedCertificateEntries = append(tmpl.RevokedCertificateEntries, x509.RevocationListEntry{
+				SerialNumber: big.NewInt(serial), RevocationTime: time.Now().Add(-time.Hour),
+			})
+		}
+		der, err := x509.CreateRevocationList(rand.Reader, tmpl, signer.cert, signer.key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return der
+	}
+	tests := []struct {
+		name        string
+		crl         []byte
+		wantRevoked bool
+		wantErr     string
+	}{
+		{name: "not listed", crl: crl(ca, []int64{999}, time.Now().Add(time.Hour))},
+		{name: "revoked", crl: crl(ca, []int64{999, 1000}, time.Now().Add(time.Hour)), wantRevoked: true},
+		{name: "other signer", crl: crl(otherCA, []int64{1000}, time.Now().Add(time.Hour)), wantErr: "not signed by the issuer"},
+		{name: "expired", crl: crl(ca, nil, time.Now().Add(-time.Hour)), wantErr: "expired"},
+		{name: "garbage", crl: []byte("not a CRL"), wantErr: "parsing CRL"},
+	}
+	s, err := newSession(options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.close()
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { w.Write(tt.crl) }))
+			defer srv.Close()
+			var revoked bool
+			captureStdout(t, func() { revoked, err = s.checkCRL(srv.URL, nil, leaf.cert, ca.cert) })
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("checkCRL() = %v, want an error about %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil || revoked != tt.wantRevoked {
+				t.Errorf("checkCRL() = %v, %v, want %v", revoked, err, tt.wantRevoked)
+			}
+		})
+	}
+}
+
+func TestRevocationFetchesOmitDefaultHeaders(t *testing.T) {
+	var seen []http.Header
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		seen = append(seen, r.Header.Clone())
+		http.Error(w, "no", http.StatusNotFound)
+	}))
+	defer srv.Close()
+
+	s, err := newSession(options{headers: []string{"Authorization: Bearer secret", "X-Tenant: a"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.close()
+	ca := newTestCA(t, "Test CA")
+	leaf := newTestCert(t, &x509.Certificate{SerialNumber: big.NewInt(1000)}, ca)
+	id, _ := newOCSPCertID(leaf.cert, ca.cert)
+	s.queryOCSP(srv.URL, nil, id, ca.cert)
+	s.checkCRL(srv.URL, nil, leaf.cert, ca.cert)
+
+	if len(seen) != 2 {
+		t.Fatalf("responder saw %d requests, want 2", len(seen))
+	}
+	for _, h := range seen {
+		for _, name := range []string{"Authorization", "X-Tenant"} {
+			if v := h.Get(name); v != "" {
+				t.Errorf("OCSP or CRL request sent %s: %s", name, v)
+			}
+		}
+	}
+}
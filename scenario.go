@@ -0,0 +1,305 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"maps"
+	"net/http"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// scenario is a sequence of requests that share one dial override map, one cookie jar and one set of variables.
+//
+// Variables are referenced as ${name} in step URLs, headers and bodies. They start out as the scenario's
+// "variables" and grow as steps extract values from their responses.
+type scenario struct {
+	Overrides map[string]string `json:"overrides"`
+	Variables map[string]string `json:"variables"`
+	Steps     []scenarioStep    `json:"steps"`
+}
+
+type scenarioStep struct {
+	Name    string                 `json:"name"`
+	Method  string                 `json:"method"`
+	URL     string                 `json:"url"`
+	Headers map[string]string      `json:"headers"`
+	Body    string                 `json:"body"`
+	Expect  scenarioExpect         `json:"expect"`
+	Extract map[string]extractRule `json:"extract"`
+}
+
+type scenarioExpect struct {
+	Status       int               `json:"status"`
+	BodyContains string            `json:"bodyContains"`
+	Headers      map[string]string `json:"headers"` // header name to regexp its value must match
+}
+
+// extractRule pulls a value out of a response. Header selects a response header instead of the body, JSON is a
+// JSONPath into the body, and Regex is applied to whatever was selected, yielding its first capture group if it
+// has one and the whole match otherwise.
+type extractRule struct {
+	Header string `json:"header"`
+	JSON   string `json:"json"`
+	Regex  string `json:"regex"`
+}
+
+var scenarioVarPattern = regexp.MustCompile(`\$\{([A-Za-z0-9_.-]+)\}`)
+
+func runScenarioFile(opts options) error {
+	data, err := os.ReadFile(opts.scenarioFile)
+	if err != nil {
+		return newError(errBadInput, "reading scenario failed: %v", err)
+	}
+	var sc scenario
+	if err := json.Unmarshal(data, &sc); err != nil {
+		return newError(errBadInput, "parsing scenario failed: %v", err)
+	}
+	if len(sc.Steps) == 0 {
+		return newError(errBadInput, "scenario %s has no steps", opts.scenarioFile)
+	}
+
+	// The scenario's own overrides take precedence over those of the profile.
+	overrides := dialOverrides{}
+	maps.Copy(overrides, opts.overrides)
+	for k, v := range sc.Overrides {
+		overrides.set(k, v)
+	}
+	if err := checkProxyOverrides(opts, overrides); err != nil {
+		return err
+	}
+
+	s, err := newSession(opts)
+	if err != nil {
+		return err
+	}
+
+	vars := make(map[string]string, len(sc.Variables))
+	for k, v := range sc.Variables {
+		vars[k] = v
+	}
+	for i, step := range sc.Steps {
+		name := step.Name
+		if name == "" {
+			name = strconv.Itoa(i + 1)
+		}
+		fmt.Printf("Step %d/%d %s\n", i+1, len(sc.Steps), name)
+		if err := runScenarioStep(s, overrides, step, vars); err != nil {
+			s.close()
+			return wrapError(err, "scenario failed at step %d (%s)", i+1, name)
+		}
+	}
+	if err := s.close(); err != nil {
+		return err
+	}
+	fmt.Printf("Scenario passed: %d steps\n", len(sc.Steps))
+	return nil
+}
+
+func runScenarioStep(s *session, overrides dialOverrides, step scenarioStep, vars map[string]string) error {
+	method := step.Method
+	if method == "" {
+		method = http.MethodGet
+	}
+	urlStr, err := expandVars(step.URL, vars)
+	if err != nil {
+		return &toolError{kind: errBadInput, err: err}
+	}
+	body, err := expandVars(step.Body, vars)
+	if err != nil {
+		return &toolError{kind: errBadInput, err: err}
+	}
+	req, err := http.NewRequest(method, urlStr, strings.NewReader(body))
+	if err != nil {
+		return newError(errBadInput, "building request failed: %v", err)
+	}
+	for k, v := range step.Headers {
+		v, err := expandVars(v, vars)
+		if err != nil {
+			return &toolError{kind: errBadInput, err: err}
+		}
+		req.Header.Set(k, v)
+	}
+	if host := req.Header.Get("Host"); host != "" {
+		req.Host = host
+	}
+
+	start := time.Now()
+	resp, respBody, err := s.fetch(req, overrides)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("  %s %s -> %s (%d bytes, %s)\n", method, urlStr, resp.Status, len(respBody), time.Since(start).Round(time.Millisecond))
+
+	if err := checkExpect(step.Expect, resp, respBody); err != nil {
+		return &toolError{kind: errCheckFailed, err: err}
+	}
+
+	names := make([]string, 0, len(step.Extract))
+	for name := range step.Extract {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		val, err := extractValue(step.Extract[name], resp, respBody)
+		if err != nil {
+			return newError(errCheckFailed, "extracting %s: %v", name, err)
+		}
+		vars[name] = val
+		fmt.Printf("  extracted %s = %q\n", name, val)
+	}
+	return nil
+}
+
+func expandVars(s string, vars map[string]string) (string, error) {
+	var missing []string
+	out := scenarioVarPattern.ReplaceAllStringFunc(s, func(m string) string {
+		name := scenarioVarPattern.FindStringSubmatch(m)[1]
+		val, ok := vars[name]
+		if !ok {
+			missing = append(missing, name)
+		}
+		return val
+	})
+	if len(missing) > 0 {
+		return "", fmt.Errorf("undefined variables: %s", strings.Join(missing, ", "))
+	}
+	return out, nil
+}
+
+func checkExpect(expect scenarioExpect, resp *http.Response, body []byte) error {
+	if expect.Status != 0 && resp.StatusCode != expect.Status {
+		return fmt.Errorf("expected status %d, got %d", expect.Status, resp.StatusCode)
+	}
+	if expect.BodyContains != "" && !strings.Contains(string(body), expect.BodyContains) {
+		return fmt.Errorf("body does not contain %q", expect.BodyContains)
+	}
+	for name, pattern := range expect.Headers {
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return fmt.Errorf("bad header pattern for %s: %w", name, err)
+		}
+		if val := resp.Header.Get(name); !re.MatchString(val) {
+			return fmt.Errorf("header %s value %q does not match %q", name, val, pattern)
+		}
+	}
+	return nil
+}
+
+func extractValue(rule extractRule, resp *http.Response, body []byte) (string, error) {
+	var src string
+	switch {
+	case rule.Header != "":
+		vals := resp.Header.Values(rule.Header)
+		if len(vals) == 0 {
+			return "", fmt.Errorf("no %s header in response", rule.Header)
+		}
+		src = vals[0]
+	case rule.JSON != "":
+		// Numbers are kept as written, so large ids do not lose digits to float64.
+		var doc any
+		dec := json.NewDecoder(bytes.NewReader(body))
+		dec.UseNumber()
+		if err := dec.Decode(&doc); err != nil {
+			return "", fmt.Errorf("body is not JSON: %w", err)
+		}
+		if _, err := dec.Token(); err != io.EOF {
+			return "", fmt.Errorf("body is not JSON: data after the top-level value")
+		}
+		val, err := jsonPath(doc, rule.JSON)
+		if err != nil {
+			return "", err
+		}
+		src = val
+	default:
+		src = string(body)
+	}
+	if rule.Regex == "" {
+		return src, nil
+	}
+	re, err := regexp.Compile(rule.Regex)
+	if err != nil {
+		return "", err
+	}
+	m := re.FindStringSubmatch(src)
+	switch {
+	case m == nil:
+		return "", fmt.Errorf("%q did not match", rule.Regex)
+	case len(m) > 1:
+		return m[1], nil
+	default:
+		return m[0], nil
+	}
+}
+
+// jsonPath evaluates the subset of JSONPath made of member names and array indexes, e.g. $.data.items[0].id or
+// $['odd key'].id, and renders the selected value as a string. Objects and arrays are rendered as JSON.
+func jsonPath(doc any, path string) (string, error) {
+	rest := strings.TrimPrefix(path, "$")
+	cur := doc
+	for rest != "" {
+		var key string
+		index := -1
+		switch {
+		case strings.HasPrefix(rest, "['"):
+			end := strings.Index(rest, "']")
+			if end < 0 {
+				return "", fmt.Errorf("jsonpath %q: unterminated ['", path)
+			}
+			key, rest = rest[2:end], rest[end+2:]
+		case strings.HasPrefix(rest, "["):
+			end := strings.Index(rest, "]")
+			if end < 0 {
+				return "", fmt.Errorf("jsonpath %q: unterminated [", path)
+			}
+			n, err := strconv.Atoi(rest[1:end])
+			if err != nil || n < 0 {
+				return "", fmt.Errorf("jsonpath %q: bad index %q", path, rest[1:end])
+			}
+			index, rest = n, rest[end+1:]
+		case strings.HasPrefix(rest, "."):
+			rest = rest[1:]
+			end := strings.IndexAny(rest, ".[")
+			if end < 0 {
+				end = len(rest)
+			}
+			key, rest = rest[:end], rest[end:]
+		default:
+			return "", fmt.Errorf("jsonpath %q: unexpected %q", path, rest)
+		}
+
+		if index >= 0 {
+			arr, ok := cur.([]any)
+			if !ok || index >= len(arr) {
+				return "", fmt.Errorf("jsonpath %q: no element %d", path, index)
+			}
+			cur = arr[index]
+			continue
+		}
+		obj, ok := cur.(map[string]any)
+		if !ok {
+			return "", fmt.Errorf("jsonpath %q: %q is not inside an object", path, key)
+		}
+		if cur, ok = obj[key]; !ok {
+			return "", fmt.Errorf("jsonpath %q: no member %q", path, key)
+		}
+	}
+
+	switch v := cur.(type) {
+	case string:
+		return v, nil
+	case json.Number:
+		return v.String(), nil
+	case nil:
+		return "null", nil
+	default:
+		out, err := json.Marshal(v)
+		return string(out), err
+	}
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestExtractValueJSON(t *testing.T) {
+	body := []byte(`{"data": {"items": [{"id": 12345678901234567890}, {"id": 1e21, "name": "b"}],
+		"odd key": {"ok": true}, "none": null, "price": 1.50, "obj": {"n": 12345678901234567890}}}`)
+	tests := []struct {
+		path    string
+		want    string
+		wantErr bool
+	}{
+		{path: "$.data.items[0].id", want: "12345678901234567890"},
+		{path: "$.data.items[1].id", want: "1e21"},
+		{path: "$.data.items[1].name", want: "b"},
+		{path: "$.data['odd key'].ok", want: "true"},
+		{path: "$.data.none", want: "null"},
+		{path: "$.data.price", want: "1.50"},
+		{path: "$.data.obj", want: `{"n":12345678901234567890}`},
+		{path: "$.data.items[2]", wantErr: true},
+		{path: "$.data.missing", wantErr: true},
+		{path: "$.data.items.id", wantErr: true},
+		{path: "$.data['odd key", wantErr: true},
+		{path: "$.data.items[-1]", wantErr: true},
+		{path: "$data", wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := extractValue(extractRule{JSON: tt.path}, &http.Response{}, body)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("%s: error = %v, want error %v", tt.path, err, tt.wantErr)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("%s = %q, want %q", tt.path, got, tt.want)
+		}
+	}
+}
+
+func TestExtractValue(t *testing.T) {
+	resp := &http.Response{Header: http.Header{"Location": {"/orders/42"}}}
+	body := []byte(`token=abc123; other`)
+	tests := []struct {
+		name    string
+		rule    extractRule
+		body    []byte
+		want    string
+		wantErr bool
+	}{
+		{name: "header", rule: extractRule{Header: "Location"}, want: "/orders/42"},
+		{name: "header regex group", rule: extractRule{Header: "Location", Regex: `/orders/(\d+)`}, want: "42"},
+		{name: "body regex match", rule: extractRule{Regex: `token=\w+`}, want: "token=abc123"},
+		{name: "missing header", rule: extractRule{Header: "ETag"}, wantErr: true},
+		{name: "no match", rule: extractRule{Regex: `nope`}, wantErr: true},
+		{name: "not JSON", rule: extractRule{JSON: "$.a"}, wantErr: true},
+		{name: "trailing data", rule: extractRule{JSON: "$.a"}, body: []byte(`{"a": 1} {"a": 2}`), wantErr: true},
+	}
+	for _, tt := range tests {
+		b := body
+		if tt.body != nil {
+			b = tt.body
+		}
+		got, err := extractValue(tt.rule, resp, b)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("%s: error = %v, want error %v", tt.name, err, tt.wantErr)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("%s = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestExpandVars(t *testing.T) {
+	vars := map[string]string{"host": "example.com", "id": "7"}
+	got, err := expandVars("https://${host}/items/${id}", vars)
+	if err != nil || got != "https://example.com/items/7" {
+		t.Errorf("expandVars() = %q, %v", got, err)
+	}
+	if _, err := expandVars("${host}/${missing}", vars); err == nil {
+		t.Errorf("expandVars() with an undefined variable succeeded")
+	}
+}
+
+func TestCheckExpect(t *testing.T) {
+	resp := &http.Response{StatusCode: 201, Header: http.Header{"Content-Type": {"application/json"}}}
+	body := []byte(`{"ok": true}`)
+	tests := []struct {
+		name    string
+		expect  scenarioExpect
+		wantErr bool
+	}{
+		{name: "empty", expect: scenarioExpect{}},
+		{name: "all match", expect: scenarioExpect{Status: 201, BodyContains: `"ok"`, Headers: map[string]string{"Content-Type": "^application/json$"}}},
+		{name: "status", expect: scenarioExpect{Status: 200}, wantErr: true},
+		{name: "body", expect: scenarioExpect{BodyContains: "error"}, wantErr: true},
+		{name: "header", expect: scenarioExpect{Headers: map[string]string{"Content-Type": "xml"}}, wantErr: true},
+		{name: "bad pattern", expect: scenarioExpect{Headers: map[string]string{"Content-Type": "("}}, wantErr: true},
+	}
+	for _, tt := range tests {
+		if err := checkExpect(tt.expect, resp, body); (err != nil) != tt.wantErr {
+			t.Errorf("%s: error = %v, want error %v", tt.name, err, tt.wantErr)
+		}
+	}
+}
+
+func TestRunScenarioFileProxyOverrides(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "scenario.json")
+	sc := `{"overrides": {"api.example.com": "127.0.0.1"}, "steps": [{"url": "http://api.example.com/"}]}`
+	if err := os.WriteFile(path, []byte(sc), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	err := runScenarioFile(options{scenarioFile: path, proxy: "http://127.0.0.1:3128"})
+	if kindOf(err) != errBadInput {
+		t.Errorf("runScenarioFile() with -proxy and overrides = %v, want bad input", err)
+	}
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"fmt"
+	"syscall"
+)
+
+const socketOptionsSupported = true
+
+// tcpFastOpenConnect is TCP_FASTOPEN_CONNECT (Linux 4.11+), which the syscall package does not define. With it
+// connect returns at once and the first write goes out in the SYN, carrying a cookie from an earlier connection.
+const tcpFastOpenConnect = 30
+
+// controlSocket applies the options that must be set before connect.
+func controlSocket(fd uintptr, d dialOptions) error {
+	s := int(fd)
+	if d.device != "" {
+		// Unprivileged processes may only use this on kernels 5.7 and newer.
+		if err := syscall.SetsockoptString(s, syscall.SOL_SOCKET, syscall.SO_BINDTODEVICE, d.device); err != nil {
+			return fmt.Errorf("binding to interface %s: %w", d.device, err)
+		}
+	}
+	if d.mark != 0 {
+		// Policy routing rules and netfilter can match on the mark. Setting it needs CAP_NET_ADMIN.
+		if err := syscall.SetsockoptInt(s, syscall.SOL_SOCKET, syscall.SO_MARK, d.mark); err != nil {
+			return fmt.Errorf("setting fwmark %d: %w", d.mark, err)
+		}
+	}
+	if d.fastOpen {
+		if err := syscall.SetsockoptInt(s, syscall.IPPROTO_TCP, tcpFastOpenConnect, 1); err != nil {
+			return fmt.Errorf("enabling TCP Fast Open: %w", err)
+		}
+	}
+	if d.congestion != "" {
+		if err := syscall.SetsockoptString(s, syscall.IPPROTO_TCP, syscall.TCP_CONGESTION, d.congestion); err != nil {
+			return fmt.Errorf("setting congestion control %s: %w", d.congestion, err)
+		}
+	}
+	// Buffer sizes are set before connect so the receive window scale advertised in the SYN accounts for them.
+	if d.sndBuf != 0 {
+		if err := syscall.SetsockoptInt(s, syscall.SOL_SOCKET, syscall.SO_SNDBUF, d.sndBuf); err != nil {
+			return fmt.Errorf("setting send buffer: %w", err)
+		}
+	}
+	if d.rcvBuf != 0 {
+		if err := syscall.SetsockoptInt(s, syscall.SOL_SOCKET, syscall.SO_RCVBUF, d.rcvBuf); err != nil {
+			return fmt.Errorf("setting receive buffer: %w", err)
+		}
+	}
+	return nil
+}
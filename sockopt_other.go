@@ -0,0 +1,11 @@
+//go:build !linux
+
+package main
+
+import "errors"
+
+const socketOptionsSupported = false
+
+func controlSocket(fd uintptr, d dialOptions) error {
+	return errors.New("socket options are only supported on Linux")
+}
@@ -0,0 +1,206 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// sseDefaultRetry is how long to wait before reconnecting when the server has not sent a retry field, as browsers do.
+const sseDefaultRetry = 3 * time.Second
+
+// sseEvent is one dispatched Server-Sent Event.
+type sseEvent struct {
+	typ, id, data string
+}
+
+// streamPrinter prints what arrives with its time since the stream started and the gap since the previous arrival.
+type streamPrinter struct {
+	start, last time.Time
+	count       int
+}
+
+func (p *streamPrinter) stamp() string {
+	now := time.Now()
+	gap := now.Sub(p.last)
+	p.last = now
+	p.count++
+	return fmt.Sprintf("at +%.3fs (gap %.3fs)", now.Sub(p.start).Seconds(), gap.Seconds())
+}
+
+// stream prints the response to a method request for urlStr, which sends body if it is not nil, as it arrives
+// instead of reading it whole: Server-Sent Events one by one, anything else chunk by chunk. It stops after maxEvents
+// events or chunks, after duration, or when the server ends the response. Event streams that end before a limit is
+// reached are reconnected with Last-Event-ID, as a browser's EventSource would, and the body is sent again.
+func (s *session) stream(urlStr string, overrides dialOverrides, method string, body []byte, maxEvents int, duration time.Duration) error {
+	ctx := context.Background()
+	if duration > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, duration)
+		defer cancel()
+	}
+	limited := maxEvents > 0 || duration > 0
+	p := &streamPrinter{start: time.Now(), last: time.Now()}
+
+	unit := "events"
+	var lastEventID string
+	retry := sseDefaultRetry
+	for {
+		req, err := s.newRequest(ctx, method, urlStr, body)
+		if err != nil {
+			return err
+		}
+		req.Header.Set("Accept", "text/event-stream, */*")
+		req.Header.Set("Cache-Control", "no-cache")
+		if lastEventID != "" {
+			req.Header.Set("Last-Event-ID", lastEventID)
+		}
+		resp, err := s.do(req, overrides)
+		if err != nil {
+			if ctx.Err() != nil {
+				break
+			}
+			return err
+		}
+		fmt.Printf("Connected: %s %s at +%.3fs\n", resp.Proto, resp.Status, time.Since(p.start).Seconds())
+		mediaType, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+		if mediaType != "text/event-stream" {
+			unit = "chunks"
+			err := p.chunks(resp.Body, maxEvents)
+			resp.Body.Close()
+			if err != nil && ctx.Err() == nil {
+				return &toolError{kind: errRead, err: fmt.Errorf("reading stream failed: %w", err)}
+			}
+			break
+		}
+		if resp.StatusCode != http.StatusOK {
+			// EventSource gives up on anything but 200; 204 is how servers ask it to stop.
+			resp.Body.Close()
+			fmt.Printf("Event stream closed by server with %s\n", resp.Status)
+			break
+		}
+
+		done, err := p.events(resp.Body, maxEvents, &lastEventID, &retry)
+		resp.Body.Close()
+		if done || ctx.Err() != nil {
+			break
+		}
+		if err != nil {
+			fmt.Printf("Stream broke at +%.3fs: %v\n", time.Since(p.start).Seconds(), err)
+		} else {
+			fmt.Printf("Stream ended by server at +%.3fs\n", time.Since(p.start).Seconds())
+		}
+		if !limited {
+			break
+		}
+		fmt.Printf("Reconnecting in %s with Last-Event-ID %q\n", retry, lastEventID)
+		select {
+		case <-time.After(retry):
+		case <-ctx.Done():
+		}
+		if ctx.Err() != nil {
+			break
+		}
+	}
+	fmt.Printf("Received %d %s in %.3fs\n", p.count, unit, time.Since(p.start).Seconds())
+	return nil
+}
+
+// chunks prints each read of body as it returns, until EOF or maxEvents reads.
+func (p *streamPrinter) chunks(body io.Reader, maxEvents int) error {
+	buf := make([]byte, 32<<10)
+	for maxEvents <= 0 || p.count < maxEvents {
+		n, err := body.Read(buf)
+		if n > 0 {
+			chunk := buf[:n]
+			stamp := p.stamp()
+			if len(chunk) > 80 {
+				fmt.Printf("Chunk %d %s: %d bytes: %q...\n", p.count, stamp, n, chunk[:80])
+			} else {
+				fmt.Printf("Chunk %d %s: %d bytes: %q\n", p.count, stamp, n, chunk)
+			}
+		}
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// events parses body as an event stream, following the HTML EventSource rules, and prints each event as it is
+// dispatched. It keeps lastEventID and retry up to date for reconnecting, and reports whether maxEvents was reached.
+func (p *streamPrinter) events(body io.Reader, maxEvents int, lastEventID *string, retry *time.Duration) (bool, error) {
+	r := bufio.NewReader(body)
+	var typ string
+	var data strings.Builder
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			// A partial event at the end of the stream is discarded.
+			if errors.Is(err, io.EOF) {
+				err = nil
+			}
+			return false, err
+		}
+		line = strings.TrimSuffix(strings.TrimSuffix(line, "\n"), "\r")
+
+		if line == "" {
+			if data.Len() > 0 {
+				ev := sseEvent{typ: typ, id: *lastEventID, data: strings.TrimSuffix(data.String(), "\n")}
+				if ev.typ == "" {
+					ev.typ = "message"
+				}
+				p.printEvent(ev)
+				if maxEvents > 0 && p.count >= maxEvents {
+					return true, nil
+				}
+			}
+			typ = ""
+			data.Reset()
+			continue
+		}
+		if strings.HasPrefix(line, ":") {
+			continue
+		}
+		field, value, _ := strings.Cut(line, ":")
+		value = strings.TrimPrefix(value, " ")
+		switch field {
+		case "event":
+			typ = value
+		case "data":
+			data.WriteString(value)
+			data.WriteByte('\n')
+		case "id":
+			if !strings.Contains(value, "\x00") {
+				*lastEventID = value
+			}
+		case "retry":
+			if ms, err := strconv.ParseUint(value, 10, 32); err == nil {
+				*retry = time.Duration(ms) * time.Millisecond
+			}
+		}
+	}
+}
+
+func (p *streamPrinter) printEvent(ev sseEvent) {
+	stamp := p.stamp()
+	fmt.Printf("Event %d %s type=%s", p.count, stamp, ev.typ)
+	if ev.id != "" {
+		fmt.Printf(" id=%s", ev.id)
+	}
+	if len(ev.data) > 200 {
+		fmt.Printf(": %q...\n", ev.data[:200])
+	} else {
+		fmt.Printf(": %q\n", ev.data)
+	}
+}
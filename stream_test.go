@@ -0,0 +1,174 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"regexp"
+	"strings"
+	"testing"
+	"testing/iotest"
+	"time"
+)
+
+// captureStdout returns what f prints.
+func captureStdout(t *testing.T, f func()) string {
+	t.Helper()
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	stdout := os.Stdout
+	os.Stdout = w
+	defer func() { os.Stdout = stdout }()
+	out := make(chan string)
+	go func() {
+		b, _ := io.ReadAll(r)
+		out <- string(b)
+	}()
+	f()
+	w.Close()
+	return <-out
+}
+
+var streamStamp = regexp.MustCompile(`at \+[0-9.]+s \(gap [0-9.]+s\)`)
+
+func TestStreamEvents(t *testing.T) {
+	tests := []struct {
+		name        string
+		stream      string
+		maxEvents   int
+		want        []string
+		wantID      string
+		wantRetry   time.Duration
+		wantReached bool
+	}{
+		{
+			name:   "types, ids and multi-line data",
+			stream: "data: one\n\nevent: tick\nid: 7\ndata: a\ndata:b\n\n",
+			want:   []string{`Event 1 type=message: "one"`, `Event 2 type=tick id=7: "a\nb"`},
+			wantID: "7",
+		},
+		{
+			name:   "CRLF, comments and fields without value",
+			stream: ": keepalive\r\ndata\r\ndata: x\r\n\r\n",
+			want:   []string{`Event 1 type=message: "\nx"`},
+		},
+		{
+			name:      "retry and id persist across events",
+			stream:    "retry: 1500\nid: 1\ndata: a\n\ndata: b\n\nretry: soon\n\n",
+			want:      []string{`Event 1 type=message id=1: "a"`, `Event 2 type=message id=1: "b"`},
+			wantID:    "1",
+			wantRetry: 1500 * time.Millisecond,
+		},
+		{
+			name:   "event without data is not dispatched",
+			stream: "event: ping\n\nid: a\x00b\ndata: c\n\n",
+			want:   []string{`Event 1 type=message: "c"`},
+		},
+		{
+			name:   "partial event at the end is discarded",
+			stream: "data: done\n\ndata: partial\n",
+			want:   []string{`Event 1 type=message: "done"`},
+		},
+		{
+			name:        "max events",
+			stream:      "data: 1\n\ndata: 2\n\ndata: 3\n\n",
+			maxEvents:   2,
+			want:        []string{`Event 1 type=message: "1"`, `Event 2 type=message: "2"`},
+			wantReached: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := &streamPrinter{start: time.Now(), last: time.Now()}
+			var id string
+			var retry time.Duration
+			var reached bool
+			var err error
+			out := captureStdout(t, func() {
+				// One byte per read, so no line arrives whole.
+				reached, err = p.events(iotest.OneByteReader(strings.NewReader(tt.stream)), tt.maxEvents, &id, &retry)
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			want := strings.Join(tt.want, "\n") + "\n"
+			if got := strings.ReplaceAll(streamStamp.ReplaceAllString(out, ""), "  ", " "); got != want {
+				t.Errorf("printed:\n%s\nwant:\n%s", got, want)
+			}
+			if id != tt.wantID || retry != tt.wantRetry || reached != tt.wantReached {
+				t.Errorf("id %q, retry %s, reached %v; want %q, %s, %v", id, retry, reached, tt.wantID, tt.wantRetry, tt.wantReached)
+			}
+		})
+	}
+}
+
+// pieceReader returns one piece per Read, as a server flushing each one would.
+type pieceReader []string
+
+func (r *pieceReader) Read(p []byte) (int, error) {
+	if len(*r) == 0 {
+		return 0, io.EOF
+	}
+	n := copy(p, (*r)[0])
+	*r = (*r)[1:]
+	return n, nil
+}
+
+func TestStreamChunks(t *testing.T) {
+	tests := []struct {
+		name      string
+		pieces    []string
+		maxEvents int
+		want      []string
+	}{
+		{
+			name:   "until EOF",
+			pieces: []string{"a", "bc", strings.Repeat("x", 81)},
+			want:   []string{`Chunk 1 : 1 bytes: "a"`, `Chunk 2 : 2 bytes: "bc"`, `Chunk 3 : 81 bytes: "` + strings.Repeat("x", 80) + `"...`},
+		},
+		{
+			name:      "max chunks",
+			pieces:    []string{"a", "b", "c"},
+			maxEvents: 2,
+			want:      []string{`Chunk 1 : 1 bytes: "a"`, `Chunk 2 : 1 bytes: "b"`},
+		},
+	}
+	for _, tt := range tests {
+		p := &streamPrinter{start: time.Now(), last: time.Now()}
+		body := pieceReader(tt.pieces)
+		var err error
+		out := captureStdout(t, func() { err = p.chunks(&body, tt.maxEvents) })
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		if got, want := streamStamp.ReplaceAllString(out, ""), strings.Join(tt.want, "\n")+"\n"; got != want {
+			t.Errorf("%s: printed:\n%s\nwant:\n%s", tt.name, got, want)
+		}
+	}
+}
+
+func TestStreamPost(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		w.Header().Set("Content-Type", "text/event-stream")
+		fmt.Fprintf(w, "data: %s %s\n\n", r.Method, body)
+	}))
+	defer srv.Close()
+
+	s, err := newSession(options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.close()
+	out := captureStdout(t, func() { err = s.stream(srv.URL, nil, http.MethodPost, []byte("q=1"), 0, 0) })
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := `"POST q=1"`; !strings.Contains(out, want) {
+		t.Errorf("output lacks %q:\n%s", want, out)
+	}
+}
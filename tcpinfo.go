@@ -0,0 +1,80 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"sync"
+	"time"
+)
+
+// tcpStats is the subset of TCP_INFO reported by -tcp-info.
+type tcpStats struct {
+	RTT          time.Duration
+	RTTVar       time.Duration
+	Retransmits  uint32 // unrecovered retransmits of the current segment
+	TotalRetrans uint32
+	Cwnd         uint32 // in segments
+	SndMSS       uint32
+	RcvMSS       uint32
+}
+
+// tcpConnLog remembers every TCP connection a session dialed so their kernel statistics can be reported once the
+// requests are done. Connections the transport closes before then are read as they close.
+type tcpConnLog struct {
+	mu    sync.Mutex
+	conns []*trackedConn
+}
+
+// trackedConn is a connection in a tcpConnLog. Once it is closed, stats and err hold its final TCP_INFO.
+type trackedConn struct {
+	*net.TCPConn
+	log *tcpConnLog
+
+	closed bool
+	stats  tcpStats
+	err    error
+}
+
+// add records conn and returns it wrapped so that closing it takes the final reading.
+func (l *tcpConnLog) add(conn net.Conn) net.Conn {
+	tc, ok := conn.(*net.TCPConn)
+	if !ok {
+		return conn
+	}
+	c := &trackedConn{TCPConn: tc, log: l}
+	l.mu.Lock()
+	l.conns = append(l.conns, c)
+	l.mu.Unlock()
+	return c
+}
+
+func (c *trackedConn) Close() error {
+	c.log.mu.Lock()
+	if !c.closed {
+		c.stats, c.err = readTCPInfo(c.TCPConn)
+		c.closed = true
+	}
+	c.log.mu.Unlock()
+	return c.TCPConn.Close()
+}
+
+func (l *tcpConnLog) report() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for _, conn := range l.conns {
+		stats, err, state := conn.stats, conn.err, "closed"
+		if !conn.closed {
+			stats, err = readTCPInfo(conn.TCPConn)
+			state = "open"
+		}
+		fmt.Printf("TCP connection %s -> %s (%s):\n", conn.LocalAddr(), conn.RemoteAddr(), state)
+		if err != nil {
+			fmt.Printf("  unavailable: %v\n", err)
+			continue
+		}
+		fmt.Printf("  RTT: %s (variance %s)\n", stats.RTT, stats.RTTVar)
+		fmt.Printf("  Retransmits: %d (current %d)\n", stats.TotalRetrans, stats.Retransmits)
+		fmt.Printf("  Congestion window: %d segments\n", stats.Cwnd)
+		fmt.Printf("  MSS: %d bytes sent, %d bytes received\n", stats.SndMSS, stats.RcvMSS)
+	}
+}
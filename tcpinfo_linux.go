@@ -0,0 +1,43 @@
+package main
+
+import (
+	"net"
+	"syscall"
+	"time"
+	"unsafe"
+)
+
+// readTCPInfo reads the kernel's TCP_INFO for the connection.
+func readTCPInfo(conn *net.TCPConn) (tcpStats, error) {
+	raw, err := conn.SyscallConn()
+	if err != nil {
+		return tcpStats{}, err
+	}
+	var (
+		info    syscall.TCPInfo
+		sockErr error
+	)
+	err = raw.Control(func(fd uintptr) {
+		size := uint32(syscall.SizeofTCPInfo)
+		_, _, errno := syscall.Syscall6(syscall.SYS_GETSOCKOPT, fd, syscall.IPPROTO_TCP, syscall.TCP_INFO,
+			uintptr(unsafe.Pointer(&info)), uintptr(unsafe.Pointer(&size)), 0)
+		if errno != 0 {
+			sockErr = errno
+		}
+	})
+	if err != nil {
+		return tcpStats{}, err
+	}
+	if sockErr != nil {
+		return tcpStats{}, sockErr
+	}
+	return tcpStats{
+		RTT:          time.Duration(info.Rtt) * time.Microsecond,
+		RTTVar:       time.Duration(info.Rttvar) * time.Microsecond,
+		Retransmits:  uint32(info.Retransmits),
+		TotalRetrans: info.Total_retrans,
+		Cwnd:         info.Snd_cwnd,
+		SndMSS:       info.Snd_mss,
+		RcvMSS:       info.Rcv_mss,
+	}, nil
+}
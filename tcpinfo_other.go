@@ -0,0 +1,12 @@
+//go:build !linux
+
+package main
+
+import (
+	"errors"
+	"net"
+)
+
+func readTCPInfo(conn *net.TCPConn) (tcpStats, error) {
+	return tcpStats{}, errors.New("TCP_INFO is only supported on Linux")
+}
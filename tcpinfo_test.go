@@ -0,0 +1,40 @@
+package main
+
+import (
+	"net"
+	"runtime"
+	"testing"
+)
+
+func TestTCPConnLogClosedConn(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("TCP_INFO is only supported on Linux")
+	}
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	conn, err := net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var l tcpConnLog
+	tracked := l.add(conn)
+	if err := tracked.Close(); err != nil {
+		t.Fatal(err)
+	}
+	c := l.conns[0]
+	if !c.closed || c.err != nil {
+		t.Fatalf("closed connection: closed=%v err=%v, want a reading taken at close", c.closed, c.err)
+	}
+	if c.stats.SndMSS == 0 {
+		t.Errorf("reading taken at close has no MSS: %+v", c.stats)
+	}
+	// A second Close must not replace the reading with one of the closed socket.
+	tracked.Close()
+	if c.err != nil {
+		t.Errorf("second Close replaced the reading: %v", c.err)
+	}
+}
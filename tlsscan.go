@@ -0,0 +1,230 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"slices"
+	"strings"
+	"time"
+)
+
+const tlsScanHandshakeTimeout = 5 * time.Second
+
+// Hybrid post-quantum groups newer than the Go version this module requires. A toolchain that does not know them
+// fails the handshake locally, which the scan reports as unsupported by the build.
+const (
+	secP256r1MLKEM768  tls.CurveID = 4587
+	secP384r1MLKEM1024 tls.CurveID = 4589
+)
+
+var tlsScanVersions = []uint16{tls.VersionTLS10, tls.VersionTLS11, tls.VersionTLS12, tls.VersionTLS13}
+
+var tlsScanGroups = []struct {
+	id   tls.CurveID
+	name string
+	pq   bool
+}{
+	{tls.X25519MLKEM768, "X25519MLKEM768", true},
+	{secP256r1MLKEM768, "SecP256r1MLKEM768", true},
+	{secP384r1MLKEM1024, "SecP384r1MLKEM1024", true},
+	{tls.X25519, "X25519", false},
+	{tls.CurveP256, "P-256", false},
+	{tls.CurveP384, "P-384", false},
+	{tls.CurveP521, "P-521", false},
+}
+
+var tlsScanALPN = []string{"h2", "http/1.1"}
+
+// tlsScanner runs handshakes with one setting varied at a time against addr, dialed through the session's
+// overrides and socket options.
+type tlsScanner struct {
+	ctx  context.Context
+	addr string
+	host string
+}
+
+// tlsProbe is the outcome of one scan handshake: the negotiated state, or why the handshake failed.
+type tlsProbe struct {
+	state    *tls.ConnectionState
+	rejected error
+}
+
+// handshake tries cfg against the server. Only a failure to connect at all is returned as an error.
+func (sc *tlsScanner) handshake(cfg *tls.Config) (tlsProbe, error) {
+	conn, err := dialOverridden(sc.ctx, "tcp", sc.addr)
+	if err != nil {
+		return tlsProbe{}, &toolError{kind: classifyError(err), err: fmt.Errorf("connecting for TLS scan failed: %w", err)}
+	}
+	defer conn.Close()
+	cfg.ServerName = sc.host
+	// The scan is about what the server negotiates; certificates are the subject of other checks.
+	cfg.InsecureSkipVerify = true
+	tc := tls.Client(conn, cfg)
+	ctx, cancel := context.WithTimeout(sc.ctx, tlsScanHandshakeTimeout)
+	defer cancel()
+	if err := tc.HandshakeContext(ctx); err != nil {
+		return tlsProbe{rejected: err}, nil
+	}
+	state := tc.ConnectionState()
+	return tlsProbe{state: &state}, nil
+}
+
+// tlsScan handshakes with the server at host:port once per TLS version, cipher suite, key exchange group and ALPN
+// protocol that Go supports, and reports which the server accepts and in which order it prefers the suites.
+func (s *session) tlsScan(host, port string, overrides dialOverrides, target string) error {
+	sc := &tlsScanner{
+		ctx:  withConnConfig(withDialOverrides(context.Background(), overrides), s.conn),
+		addr: net.JoinHostPort(host, port),
+		host: host,
+	}
+	fmt.Printf("TLS scan of %s", sc.addr)
+	if target != "" {
+		fmt.Printf(" via %s", target)
+	}
+	fmt.Printf("\n")
+
+	fmt.Printf("Versions:\n")
+	var tls13 bool
+	for _, v := range tlsScanVersions {
+		probe, err := sc.handshake(&tls.Config{MinVersion: v, MaxVersion: v})
+		if err != nil {
+			return err
+		}
+		if probe.state == nil {
+			fmt.Printf("  %s: rejected (%v)\n", tls.VersionName(v), probe.rejected)
+			continue
+		}
+		fmt.Printf("  %s: accepted\n", tls.VersionName(v))
+		if v == tls.VersionTLS13 {
+			tls13 = true
+			fmt.Printf("    Go does not offer TLS 1.3 suites one by one; negotiated %s\n", tls.CipherSuiteName(probe.state.CipherSuite))
+		}
+	}
+
+	var suites []*tls.CipherSuite
+	for _, cs := range append(tls.CipherSuites(), tls.InsecureCipherSuites()...) {
+		if slices.ContainsFunc(cs.SupportedVersions, func(v uint16) bool { return v <= tls.VersionTLS12 }) {
+			suites = append(suites, cs)
+		}
+	}
+	fmt.Printf("Cipher suites up to TLS 1.2:\n")
+	var accepted []uint16
+	for _, cs := range suites {
+		cfg := &tls.Config{MinVersion: tls.VersionTLS10, MaxVersion: tls.VersionTLS12, CipherSuites: []uint16{cs.ID}}
+		probe, err := sc.handshake(cfg)
+		if err != nil {
+			return err
+		}
+		if probe.state != nil {
+			accepted = append(accepted, cs.ID)
+			note := ""
+			if cs.Insecure {
+				note = ", insecure"
+			}
+			fmt.Printf("  %s: accepted (%s%s)\n", cs.Name, tls.VersionName(probe.state.Version), note)
+		}
+	}
+	if len(accepted) == 0 {
+		fmt.Printf("  none accepted\n")
+	} else if err := sc.reportSuiteOrder(accepted); err != nil {
+		return err
+	}
+
+	fmt.Printf("Key exchange groups:\n")
+	for _, g := range tlsScanGroups {
+		probe, err := sc.handshake(&tls.Config{MinVersion: tls.VersionTLS12, CurvePreferences: []tls.CurveID{g.id}})
+		if err != nil {
+			return err
+		}
+		note := ""
+		if g.pq {
+			note = " (post-quantum hybrid)"
+		}
+		switch {
+		case probe.state != nil:
+			fmt.Printf("  %s: accepted%s\n", g.name, note)
+		case g.pq && !tls13:
+			fmt.Printf("  %s: rejected%s, needs TLS 1.3\n", g.name, note)
+		case strings.HasPrefix(probe.rejected.Error(), "tls: no supported"):
+			// Failed locally, before anything was sent.
+			fmt.Printf("  %s: not supported by this build of the tool\n", g.name)
+		default:
+			fmt.Printf("  %s: rejected%s (%v)\n", g.name, note, probe.rejected)
+		}
+	}
+
+	fmt.Printf("ALPN:\n")
+	for _, proto := range tlsScanALPN {
+		probe, err := sc.handshake(&tls.Config{NextProtos: []string{proto}})
+		if err != nil {
+			return err
+		}
+		switch {
+		case probe.state == nil:
+			fmt.Printf("  %s: rejected (%v)\n", proto, probe.rejected)
+		case probe.state.NegotiatedProtocol == proto:
+			fmt.Printf("  %s: accepted\n", proto)
+		default:
+			fmt.Printf("  %s: not selected (handshake completed without ALPN)\n", proto)
+		}
+	}
+	// Offer the list in reverse order of preference to see whether the server overrides the client.
+	reversed := slices.Clone(tlsScanALPN)
+	slices.Reverse(reversed)
+	probe, err := sc.handshake(&tls.Config{NextProtos: reversed})
+	if err != nil {
+		return err
+	}
+	if probe.state != nil && probe.state.NegotiatedProtocol != "" {
+		fmt.Printf("  offered %s, server chose %s\n", strings.Join(reversed, ", "), probe.state.NegotiatedProtocol)
+	}
+	return nil
+}
+
+// reportSuiteOrder works out whether the server picks cipher suites by its own preference and, if so, in which
+// order, by offering the accepted suites and taking away the one chosen each time.
+func (sc *tlsScanner) reportSuiteOrder(accepted []uint16) error {
+	if len(accepted) == 1 {
+		return nil
+	}
+	chosen := func(offer []uint16) (uint16, error) {
+		cfg := &tls.Config{MinVersion: tls.VersionTLS10, MaxVersion: tls.VersionTLS12, CipherSuites: offer}
+		probe, err := sc.handshake(cfg)
+		if err != nil {
+			return 0, err
+		}
+		if probe.state == nil {
+			return 0, fmt.Errorf("handshake offering accepted suites failed: %w", probe.rejected)
+		}
+		return probe.state.CipherSuite, nil
+	}
+
+	first, err := chosen(accepted)
+	if err != nil {
+		return err
+	}
+	reversed := slices.Clone(accepted)
+	slices.Reverse(reversed)
+	last, err := chosen(reversed)
+	if err != nil {
+		return err
+	}
+	if first != last {
+		fmt.Printf("Suite order: client preference (server picks the first suite offered that it supports)\n")
+		return nil
+	}
+
+	fmt.Printf("Suite order: server preference\n")
+	remaining := slices.Clone(accepted)
+	for i := 1; len(remaining) > 0; i++ {
+		id, err := chosen(remaining)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("  %d. %s\n", i, tls.CipherSuiteName(id))
+		remaining = slices.DeleteFunc(remaining, func(s uint16) bool { return s == id })
+	}
+	return nil
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"crypto/tls"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestTLSScan(t *testing.T) {
+	tests := []struct {
+		name     string
+		config   *tls.Config
+		want     []string
+		wantNot  []string
+		wantLine string // a run of consecutive lines
+	}{
+		{
+			name: "TLS 1.2 only",
+			config: &tls.Config{
+				MinVersion:       tls.VersionTLS12,
+				MaxVersion:       tls.VersionTLS12,
+				CipherSuites:     []uint16{tls.TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384, tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256},
+				CurvePreferences: []tls.CurveID{tls.CurveP256},
+			},
+			want: []string{
+				"  TLS 1.0: rejected",
+				"  TLS 1.1: rejected",
+				"  TLS 1.2: accepted",
+				"  TLS 1.3: rejected",
+				"  TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256: accepted (TLS 1.2)",
+				"  TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384: accepted (TLS 1.2)",
+				"  P-256: accepted",
+				"  X25519: rejected",
+				"  X25519MLKEM768: rejected (post-quantum hybrid), needs TLS 1.3",
+				"  http/1.1: accepted",
+			},
+			wantNot: []string{"CHACHA20_POLY1305_SHA256: accepted", "CBC_SHA: accepted", "P-384: accepted"},
+			// Go servers choose by their own order, which puts AES-128 ahead of AES-256.
+			wantLine: "Suite order: server preference\n  1. TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256\n  2. TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384\n",
+		},
+		{
+			name:   "TLS 1.3 only",
+			config: &tls.Config{MinVersion: tls.VersionTLS13, CurvePreferences: []tls.CurveID{tls.X25519}},
+			want: []string{
+				"  TLS 1.2: rejected",
+				"  TLS 1.3: accepted",
+				"    Go does not offer TLS 1.3 suites one by one; negotiated TLS_",
+				"  X25519: accepted",
+				"  P-256: rejected",
+				"  X25519MLKEM768: rejected (post-quantum hybrid) (",
+			},
+			wantNot:  []string{"Suite order"},
+			wantLine: "Cipher suites up to TLS 1.2:\n  none accepted\n",
+		},
+	}
+	for _, tt := range tests {
+		srv := httptest.NewUnstartedServer(http.HandlerFunc(helloHandler))
+		srv.TLS = tt.config
+		srv.Config.ErrorLog = log.New(io.Discard, "", 0) // every rejected probe is a handshake error
+		srv.StartTLS()
+		host, port, _ := net.SplitHostPort(srv.Listener.Addr().String())
+
+		s, err := newSession(options{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		out := captureStdout(t, func() { err = s.tlsScan(host, port, nil, "") })
+		s.close()
+		srv.Close()
+		if err != nil {
+			t.Errorf("%s: tlsScan() = %v", tt.name, err)
+		}
+		for _, want := range append(tt.want, tt.wantLine) {
+			if !strings.Contains(out, want) {
+				t.Errorf("%s: output lacks %q:\n%s", tt.name, want, out)
+			}
+		}
+		for _, unwanted := range tt.wantNot {
+			if strings.Contains(out, unwanted) {
+				t.Errorf("%s: output has %q:\n%s", tt.name, unwanted, out)
+			}
+		}
+	}
+}
@@ -0,0 +1,216 @@
+package main
+
+import (
+	"bytes"
+	"crypto/tls"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptrace"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// secretHeaders are the headers whose values -v replaces with a placeholder.
+var secretHeaders = map[string]bool{
+	"authorization":       true,
+	"proxy-authorization": true,
+	"cookie":              true,
+	"set-cookie":          true,
+	"x-api-key":           true,
+	"x-auth-token":        true,
+	"x-csrf-token":        true,
+}
+
+func redactHeader(name, value string) string {
+	if secretHeaders[strings.ToLower(name)] {
+		return fmt.Sprintf("[redacted %d bytes]", len(value))
+	}
+	return value
+}
+
+// verboseLog serializes -v output, which comes from the transport's reader and writer goroutines as well as from
+// the request goroutine.
+type verboseLog struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+func newVerboseLog(w io.Writer) *verboseLog {
+	return &verboseLog{w: w}
+}
+
+func (l *verboseLog) printf(format string, args ...any) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	fmt.Fprintf(l.w, format+"\n", args...)
+}
+
+func (l *verboseLog) lines(prefix string, lines []string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for _, line := range lines {
+		fmt.Fprintf(l.w, "%s%s\n", prefix, line)
+	}
+}
+
+// verboseTransport reports the parts of an exchange that are not visible on the wire as plaintext HTTP/1.1:
+// connection reuse, the TLS handshake, and the headers of HTTP/2 exchanges, which are HPACK-compressed.
+type verboseTransport struct {
+	next http.RoundTripper
+	log  *verboseLog
+}
+
+func (t *verboseTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	var (
+		mu        sync.Mutex
+		h2        bool
+		h2Headers []string
+	)
+	trace := &httptrace.ClientTrace{
+		GotConn: func(info httptrace.GotConnInfo) {
+			if info.Reused {
+				t.log.printf("* Re-using connection to %s", info.Conn.RemoteAddr())
+			}
+			if proto, ok := negotiatedProtocol(info.Conn); ok {
+				mu.Lock()
+				h2 = proto == "h2"
+				mu.Unlock()
+			}
+		},
+		TLSHandshakeStart: func() {
+			t.log.printf("* TLS handshake with %s", req.URL.Hostname())
+		},
+		TLSHandshakeDone: func(cs tls.ConnectionState, err error) {
+			if err != nil {
+				t.log.printf("* TLS handshake failed: %v", err)
+				return
+			}
+			t.log.printf("* TLS handshake done: %s, %s, ALPN %q", tls.VersionName(cs.Version), tls.CipherSuiteName(cs.CipherSuite), cs.NegotiatedProtocol)
+			if len(cs.PeerCertificates) > 0 {
+				leaf := cs.PeerCertificates[0]
+				t.log.printf("*   subject: %s", leaf.Subject)
+				t.log.printf("*   issuer: %s", leaf.Issuer)
+				t.log.printf("*   expires: %s", leaf.NotAfter.UTC())
+			}
+		},
+		WroteHeaderField: func(key string, value []string) {
+			mu.Lock()
+			defer mu.Unlock()
+			if !h2 {
+				return
+			}
+			for _, v := range value {
+				h2Headers = append(h2Headers, key+": "+redactHeader(key, v))
+			}
+		},
+		WroteHeaders: func() {
+			mu.Lock()
+			defer mu.Unlock()
+			if h2 {
+				t.log.lines("> ", append(h2Headers, ""))
+			}
+		},
+	}
+	resp, err := t.next.RoundTrip(req.WithContext(httptrace.WithClientTrace(req.Context(), trace)))
+	if err != nil {
+		return nil, err
+	}
+	if resp.ProtoMajor == 2 {
+		// HTTP/2 header order and case are lost in HPACK decoding, so fall back to a stable order.
+		lines := []string{":status: " + fmt.Sprint(resp.StatusCode)}
+		names := make([]string, 0, len(resp.Header))
+		for name := range resp.Header {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			for _, v := range resp.Header[name] {
+				lines = append(lines, strings.ToLower(name)+": "+redactHeader(name, v))
+			}
+		}
+		t.log.lines("< ", append(lines, ""))
+	}
+	return resp, nil
+}
+
+// h1HeaderDump prints HTTP/1.1 request and response header blocks exactly as they crossed the connection. HTTP/1.1
+// has one exchange in flight per connection, so each request header block is followed by response header blocks
+// (any 1xx ones, then the final one) before the next request begins.
+type h1HeaderDump struct {
+	log *verboseLog
+
+	mu         sync.Mutex
+	writing    bool // a request header block is being written
+	awaiting   bool // a response header block is expected
+	wbuf, rbuf bytes.Buffer
+}
+
+func newH1HeaderDump(log *verboseLog) *h1HeaderDump {
+	return &h1HeaderDump{log: log}
+}
+
+func (d *h1HeaderDump) wrote(p []byte) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if !d.writing && !d.awaiting {
+		d.writing = true
+		d.wbuf.Reset()
+	}
+	if !d.writing {
+		return
+	}
+	d.wbuf.Write(p)
+	if block, _, ok := cutHeaderBlock(d.wbuf.Bytes()); ok {
+		d.log.lines("> ", d.redactBlock(block))
+		d.writing, d.awaiting = false, true
+		d.rbuf.Reset()
+	}
+}
+
+func (d *h1HeaderDump) read(p []byte) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if !d.awaiting {
+		return
+	}
+	d.rbuf.Write(p)
+	for d.awaiting {
+		block, rest, ok := cutHeaderBlock(d.rbuf.Bytes())
+		if !ok {
+			return
+		}
+		d.log.lines("< ", d.redactBlock(block))
+		// Anything but an interim 1xx ends the exchange as far as headers go; the rest is body. 101 Switching
+		// Protocols is final too, since the connection stops being HTTP after it.
+		if status := strings.Fields(block[0]); len(status) < 2 || !strings.HasPrefix(status[1], "1") || status[1] == "101" {
+			d.awaiting = false
+		}
+		remaining := append([]byte(nil), rest...)
+		d.rbuf.Reset()
+		d.rbuf.Write(remaining)
+	}
+}
+
+func (d *h1HeaderDump) redactBlock(block []string) []string {
+	out := make([]string, len(block))
+	out[0] = block[0]
+	for i, line := range block[1:] {
+		if name, value, ok := strings.Cut(line, ":"); ok {
+			line = name + ": " + redactHeader(name, strings.TrimSpace(value))
+		}
+		out[i+1] = line
+	}
+	return out
+}
+
+// cutHeaderBlock splits a start line plus header lines, ending with a blank line, off the front of buf. The blank
+// line is kept as an empty last element.
+func cutHeaderBlock(buf []byte) (block []string, rest []byte, ok bool) {
+	head, rest, ok := bytes.Cut(buf, []byte("\r\n\r\n"))
+	if !ok {
+		return nil, buf, false
+	}
+	return append(strings.Split(string(head), "\r\n"), ""), rest, true
+}
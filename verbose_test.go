@@ -0,0 +1,141 @@
+package main
+
+import (
+	"bytes"
+	"crypto/x509"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// trustServer makes the shared TLS config trust srv's certificate for the duration of the test.
+func trustServer(t *testing.T, srv *httptest.Server) {
+	t.Helper()
+	pool := x509.NewCertPool()
+	pool.AddCert(srv.Certificate())
+	old := sharedTLSConfig.RootCAs
+	sharedTLSConfig.RootCAs = pool
+	t.Cleanup(func() { sharedTLSConfig.RootCAs = old })
+}
+
+// newTLSTestServer starts an HTTPS server, speaking HTTP/2 when h2 is set, that the shared TLS config trusts.
+func newTLSTestServer(t *testing.T, h2 bool, handler http.Handler) *httptest.Server {
+	t.Helper()
+	srv := httptest.NewUnstartedServer(handler)
+	srv.EnableHTTP2 = h2
+	srv.StartTLS()
+	t.Cleanup(srv.Close)
+	trustServer(t, srv)
+	return srv
+}
+
+// verboseSession returns a session with opts whose -v and -h2-frames output goes to the returned buffer.
+func verboseSession(t *testing.T, opts options) (*session, *bytes.Buffer) {
+	t.Helper()
+	opts.noDelay = true
+	s, err := newSession(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	for _, log := range []*verboseLog{s.conn.verbose, s.conn.h2Frames} {
+		if log != nil {
+			log.w = &buf
+		}
+	}
+	t.Cleanup(func() { s.close() })
+	return s, &buf
+}
+
+func helloHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain")
+	io.WriteString(w, "hello over "+r.Proto)
+}
+
+func TestVerbose(t *testing.T) {
+	tests := []struct {
+		name      string
+		h2        bool
+		wantProto string
+		want      []string
+	}{
+		{
+			name:      "HTTP/2",
+			h2:        true,
+			wantProto: "HTTP/2.0",
+			want:      []string{"* TLS handshake done: TLS 1.3", `ALPN "h2"`, "> :method: GET", "< :status: 200", "< content-type: text/plain"},
+		},
+		{
+			name:      "HTTP/1.1",
+			wantProto: "HTTP/1.1",
+			want:      []string{"* TLS handshake done: TLS 1.3", "> GET / HTTP/1.1", "< HTTP/1.1 200 OK", "< Content-Type: text/plain"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := newTLSTestServer(t, tt.h2, http.HandlerFunc(helloHandler))
+			s, buf := verboseSession(t, options{verbose: true})
+			for i := 0; i < 2; i++ {
+				req, _ := http.NewRequest(http.MethodGet, srv.URL+"/", nil)
+				resp, body, err := s.fetch(req, nil)
+				if err != nil {
+					t.Fatalf("request %d: %v", i+1, err)
+				}
+				if resp.Proto != tt.wantProto || string(body) != "hello over "+tt.wantProto {
+					t.Fatalf("request %d: got %s %q, want %s", i+1, resp.Proto, body, tt.wantProto)
+				}
+			}
+			s.close()
+			out := buf.String()
+			for _, want := range tt.want {
+				if !strings.Contains(out, want) {
+					t.Errorf("-v output lacks %q:\n%s", want, out)
+				}
+			}
+			if n := strings.Count(out, "* TLS handshake done"); n != 1 {
+				t.Errorf("-v output shows %d handshakes, want 1 with the connection reused:\n%s", n, out)
+			}
+			if !strings.Contains(out, "* Re-using connection") {
+				t.Errorf("-v output does not show the connection being reused:\n%s", out)
+			}
+		})
+	}
+}
+
+func TestRedactHeader(t *testing.T) {
+	tests := []struct{ name, value, want string }{
+		{"Authorization", "Bearer abc", "[redacted 10 bytes]"},
+		{"cookie", "a=b", "[redacted 3 bytes]"},
+		{"Content-Type", "text/plain", "text/plain"},
+	}
+	for _, tt := range tests {
+		if got := redactHeader(tt.name, tt.value); got != tt.want {
+			t.Errorf("redactHeader(%q, %q) = %q, want %q", tt.name, tt.value, got, tt.want)
+		}
+	}
+}
+
+func TestH1HeaderDump(t *testing.T) {
+	var buf bytes.Buffer
+	d := newH1HeaderDump(newVerboseLog(&buf))
+	// Writes and reads arrive in arbitrary pieces; bodies must not be mistaken for headers.
+	d.wrote([]byte("POST /x HTTP/1.1\r\nHost: a\r\nAuthor"))
+	d.wrote([]byte("ization: secret\r\n\r\nbody\r\n\r\n"))
+	d.read([]byte("HTTP/1.1 100 Continue\r\n\r\nHTTP/1.1 200 OK\r\nContent-Length: 4\r\n"))
+	d.read([]byte("\r\nbody"))
+	d.wrote([]byte("GET /y HTTP/1.1\r\nHost: a\r\n\r\n"))
+	d.read([]byte("HTTP/1.1 204 No Content\r\n\r\n"))
+
+	want := strings.Join([]string{
+		"> POST /x HTTP/1.1", "> Host: a", "> Authorization: [redacted 6 bytes]", "> ",
+		"< HTTP/1.1 100 Continue", "< ",
+		"< HTTP/1.1 200 OK", "< Content-Length: 4", "< ",
+		"> GET /y HTTP/1.1", "> Host: a", "> ",
+		"< HTTP/1.1 204 No Content", "< ",
+	}, "\n") + "\n"
+	if got := buf.String(); got != want {
+		t.Errorf("dump:\n%s\nwant:\n%s", got, want)
+	}
+}
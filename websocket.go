@@ -0,0 +1,256 @@
+package main
+
+import (
+	"bufio"
+	"crypto/rand"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// wsGUID is appended to Sec-WebSocket-Key to compute Sec-WebSocket-Accept (RFC 6455 section 1.3).
+const wsGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+const (
+	wsContinuation = 0x0
+	wsText         = 0x1
+	wsBinary       = 0x2
+	wsClose        = 0x8
+	wsPing         = 0x9
+	wsPong         = 0xa
+)
+
+func wsAccept(key string) string {
+	sum := sha1.Sum([]byte(key + wsGUID))
+	return base64.StdEncoding.EncodeToString(sum[:])
+}
+
+// probeWebSocket performs the opening handshake against urlStr, a ws:// or wss:// URL, and checks the server's
+// answer. With a message, it also sends it as a text frame and waits up to timeout for the reply.
+func (s *session) probeWebSocket(urlStr string, overrides dialOverrides, target, message string, timeout time.Duration) error {
+	httpURL := "http" + strings.TrimPrefix(urlStr, "ws")
+	req, err := http.NewRequest(http.MethodGet, httpURL, nil)
+	if err != nil {
+		return newError(errBadInput, "building request failed: %v", err)
+	}
+	nonce := make([]byte, 16)
+	if _, err := rand.Read(nonce); err != nil {
+		return err
+	}
+	key := base64.StdEncoding.EncodeToString(nonce)
+	req.Header.Set("Connection", "Upgrade")
+	req.Header.Set("Upgrade", "websocket")
+	req.Header.Set("Sec-WebSocket-Version", "13")
+	req.Header.Set("Sec-WebSocket-Key", key)
+	req = req.WithContext(withHTTP1Only(req.Context()))
+
+	fmt.Printf("WebSocket probe of %s", urlStr)
+	if target != "" {
+		fmt.Printf(" via %s", target)
+	}
+	fmt.Printf("\n")
+	start := time.Now()
+	resp, err := s.do(req, overrides)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	handshake := time.Since(start)
+
+	if resp.StatusCode != http.StatusSwitchingProtocols {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return newError(errCheckFailed, "upgrade refused with %s: %q", resp.Status, body)
+	}
+	if !strings.EqualFold(resp.Header.Get("Upgrade"), "websocket") {
+		return newError(errCheckFailed, "101 response has Upgrade %q, want websocket", resp.Header.Get("Upgrade"))
+	}
+	if got, want := resp.Header.Get("Sec-WebSocket-Accept"), wsAccept(key); got != want {
+		return newError(errCheckFailed, "Sec-WebSocket-Accept is %q, want %q", got, want)
+	}
+	fmt.Printf("Handshake: OK, 101 Switching Protocols in %s\n", handshake.Round(time.Microsecond))
+	for _, name := range []string{"Sec-WebSocket-Protocol", "Sec-WebSocket-Extensions"} {
+		if v := resp.Header.Get(name); v != "" {
+			fmt.Printf("  %s: %s\n", name, v)
+		}
+	}
+
+	conn, ok := resp.Body.(io.ReadWriteCloser)
+	if !ok {
+		return newError(errProtocol, "upgraded connection is not writable")
+	}
+	ws := &wsConn{rw: conn, r: bufio.NewReader(conn)}
+	// The body has no deadlines, so a stuck server is dealt with by closing it underneath the reads.
+	timer := time.AfterFunc(timeout, func() { conn.Close() })
+	defer timer.Stop()
+
+	if message != "" {
+		start = time.Now()
+		if err := ws.writeFrame(wsText, []byte(message)); err != nil {
+			return &toolError{kind: errRead, err: fmt.Errorf("sending message failed: %w", err)}
+		}
+		opcode, reply, err := ws.readMessage()
+		if err != nil {
+			return &toolError{kind: errRead, err: fmt.Errorf("waiting for reply failed (timeout %s): %w", timeout, err)}
+		}
+		kind := "text"
+		if opcode == wsBinary {
+			kind = "binary"
+		}
+		fmt.Printf("Round trip: %s\n", time.Since(start).Round(time.Microsecond))
+		if len(reply) > 200 {
+			fmt.Printf("Reply (%s, %d bytes): %q...\n", kind, len(reply), reply[:200])
+		} else {
+			fmt.Printf("Reply (%s, %d bytes): %q\n", kind, len(reply), reply)
+		}
+	}
+
+	// Close cleanly with 1000 (normal closure) and wait for the server's close, skipping data still in flight.
+	if err := ws.writeFrame(wsClose, []byte{0x03, 0xe8}); err == nil {
+		for {
+			_, _, err := ws.readMessage()
+			var closed *wsCloseError
+			if errors.As(err, &closed) {
+				fmt.Printf("Closed: %s\n", closed)
+			}
+			if err != nil {
+				break
+			}
+		}
+	}
+	return nil
+}
+
+// wsConn reads and writes frames on an upgraded connection, as a client: frames it sends are masked.
+type wsConn struct {
+	rw io.ReadWriter
+	r  *bufio.Reader
+}
+
+type wsCloseError struct {
+	code   uint16
+	reason string
+}
+
+func (e *wsCloseError) Error() string {
+	if e.code == 0 {
+		return "connection closed by server"
+	}
+	if e.reason != "" {
+		return fmt.Sprintf("connection closed by server with %d (%s)", e.code, e.reason)
+	}
+	return fmt.Sprintf("connection closed by server with %d", e.code)
+}
+
+func (c *wsConn) writeFrame(opcode byte, payload []byte) error {
+	header := []byte{0x80 | opcode}
+	switch n := len(payload); {
+	case n < 126:
+		header = append(header, 0x80|byte(n))
+	case n <= 0xffff:
+		header = append(header, 0x80|126)
+		header = binary.BigEndian.AppendUint16(header, uint16(n))
+	default:
+		header = append(header, 0x80|127)
+		header = binary.BigEndian.AppendUint64(header, uint64(n))
+	}
+	mask := make([]byte, 4)
+	if _, err := rand.Read(mask); err != nil {
+		return err
+	}
+	frame := append(header, mask...)
+	for i, b := range payload {
+		frame = append(frame, b^mask[i%4])
+	}
+	_, err := c.rw.Write(frame)
+	return err
+}
+
+// readMessage returns the next data message, joining fragments and answering pings on the way. A close frame is
+// returned as a *wsCloseError.
+func (c *wsConn) readMessage() (byte, []byte, error) {
+	var opcode byte
+	var message []byte
+	for {
+		fin, op, payload, err := c.readFrame()
+		if err != nil {
+			return 0, nil, err
+		}
+		switch op {
+		case wsPing:
+			if err := c.writeFrame(wsPong, payload); err != nil {
+				return 0, nil, err
+			}
+			continue
+		case wsPong:
+			continue
+		case wsClose:
+			closed := &wsCloseError{}
+			if len(payload) >= 2 {
+				closed.code = binary.BigEndian.Uint16(payload)
+				closed.reason = string(payload[2:])
+			}
+			return 0, nil, closed
+		case wsText, wsBinary:
+			opcode = op
+		case wsContinuation:
+		default:
+			return 0, nil, fmt.Errorf("unknown opcode %#x", op)
+		}
+		message = append(message, payload...)
+		if fin {
+			return opcode, message, nil
+		}
+	}
+}
+
+func (c *wsConn) readFrame() (fin bool, opcode byte, payload []byte, err error) {
+	var head [2]byte
+	if _, err = io.ReadFull(c.r, head[:]); err != nil {
+		return
+	}
+	fin = head[0]&0x80 != 0
+	opcode = head[0] & 0x0f
+	masked := head[1]&0x80 != 0
+	n := uint64(head[1] & 0x7f)
+	switch n {
+	case 126:
+		var ext [2]byte
+		if _, err = io.ReadFull(c.r, ext[:]); err != nil {
+			return
+		}
+		n = uint64(binary.BigEndian.Uint16(ext[:]))
+	case 127:
+		var ext [8]byte
+		if _, err = io.ReadFull(c.r, ext[:]); err != nil {
+			return
+		}
+		n = binary.BigEndian.Uint64(ext[:])
+	}
+	if n > 16<<20 {
+		err = fmt.Errorf("frame of %d bytes is too large", n)
+		return
+	}
+	var mask [4]byte
+	if masked {
+		// Servers must not mask, but unmasking costs nothing and keeps the reply readable.
+		if _, err = io.ReadFull(c.r, mask[:]); err != nil {
+			return
+		}
+	}
+	payload = make([]byte, n)
+	if _, err = io.ReadFull(c.r, payload); err != nil {
+		return
+	}
+	if masked {
+		for i := range payload {
+			payload[i] ^= mask[i%4]
+		}
+	}
+	return
+}
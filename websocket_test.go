@@ -0,0 +1,95 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"slices"
+	"testing"
+)
+
+func TestWSAccept(t *testing.T) {
+	// The example from RFC 6455 section 1.3.
+	if got := wsAccept("dGhlIHNhbXBsZSBub25jZQ=="); got != "s3pPLMBiTxaQ9kYGzzhZRbK+xOo=" {
+		t.Errorf("wsAccept() = %q", got)
+	}
+}
+
+// wsServerFrame builds an unmasked frame, as servers send them.
+func wsServerFrame(fin bool, opcode byte, payload []byte) []byte {
+	b0 := opcode
+	if fin {
+		b0 |= 0x80
+	}
+	return append([]byte{b0, byte(len(payload))}, payload...)
+}
+
+func newTestWSConn(in []byte) (*wsConn, *bytes.Buffer) {
+	var out bytes.Buffer
+	return &wsConn{rw: &out, r: bufio.NewReader(bytes.NewReader(in))}, &out
+}
+
+func TestWSFrameRoundTrip(t *testing.T) {
+	for _, n := range []int{0, 125, 126, 0xffff, 0x10000} {
+		payload := bytes.Repeat([]byte{'x'}, n)
+		w, out := newTestWSConn(nil)
+		if err := w.writeFrame(wsBinary, payload); err != nil {
+			t.Fatal(err)
+		}
+		if out.Bytes()[1]&0x80 == 0 {
+			t.Errorf("%d byte frame is not masked", n)
+		}
+		r, _ := newTestWSConn(out.Bytes())
+		fin, opcode, got, err := r.readFrame()
+		if err != nil {
+			t.Fatalf("%d byte frame: %v", n, err)
+		}
+		if !fin || opcode != wsBinary || !bytes.Equal(got, payload) {
+			t.Errorf("%d byte frame read back as fin=%v opcode=%#x len=%d", n, fin, opcode, len(got))
+		}
+	}
+}
+
+func TestWSReadMessage(t *testing.T) {
+	in := slices.Concat(
+		wsServerFrame(true, wsPing, []byte("p")),
+		wsServerFrame(false, wsText, []byte("hel")),
+		wsServerFrame(true, wsPong, nil),
+		wsServerFrame(true, wsContinuation, []byte("lo")),
+		wsServerFrame(true, wsClose, append(binary.BigEndian.AppendUint16(nil, 1001), "bye"...)),
+	)
+	c, out := newTestWSConn(in)
+	opcode, message, err := c.readMessage()
+	if err != nil || opcode != wsText || string(message) != "hello" {
+		t.Fatalf("readMessage() = %#x %q %v, want the joined text message", opcode, message, err)
+	}
+	// The ping was answered with a pong carrying its payload.
+	pong, _ := newTestWSConn(out.Bytes())
+	if _, op, payload, err := pong.readFrame(); err != nil || op != wsPong || string(payload) != "p" {
+		t.Errorf("reply to ping = %#x %q %v, want a pong", op, payload, err)
+	}
+
+	_, _, err = c.readMessage()
+	var closed *wsCloseError
+	if !errors.As(err, &closed) || closed.code != 1001 || closed.reason != "bye" {
+		t.Errorf("readMessage() at close = %v, want a close error with 1001 bye", err)
+	}
+}
+
+func TestWSReadFrameErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		in   []byte
+	}{
+		{"unknown opcode", wsServerFrame(true, 0x3, nil)},
+		{"too large", []byte{0x82, 127, 0, 0, 0, 0, 0x10, 0, 0, 0}},
+		{"truncated", []byte{0x81, 5, 'a'}},
+	}
+	for _, tt := range tests {
+		c, _ := newTestWSConn(tt.in)
+		if _, _, err := c.readMessage(); err == nil {
+			t.Errorf("%s: readMessage() succeeded", tt.name)
+		}
+	}
+}